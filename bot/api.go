@@ -0,0 +1,284 @@
+package bot
+
+import (
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiPrefix          = "/api/v1/"
+	defaultAPIPageSize = 50
+	maxAPIPageSize     = 200
+	dateLayout         = "2006-01-02"
+)
+
+// API serves the archive of postings as read-only JSON. With a token set
+// requests need the "Authorization: Bearer <token>" header.
+type API struct {
+	Token     string `json:"token"`
+	TokenFile string `json:"token_file"`
+}
+
+func (a *API) compile() error {
+	var err error
+	if a.Token, err = ReadSecret(a.Token, a.TokenFile); err != nil {
+		return errors.New("Can't read token of api: " + err.Error())
+	}
+	return nil
+}
+
+// vacancy is a posting with the ID it is available under in the API.
+type vacancy struct {
+	ID string `json:"id"`
+	posting
+}
+
+// postingID is a stable ID of a posting derived from its text, which is
+// the key of the posting in the store.
+func postingID(text string) string {
+	sum := sha1.Sum([]byte(text))
+	return hex.EncodeToString(sum[:8])
+}
+
+// vacancyQuery filters postings: from and to are dates or RFC 3339 times,
+// a date in to includes the whole day; tag may repeat and every tag has to
+// match; author is the Slack name; q holds keywords.
+type vacancyQuery struct {
+	From, To time.Time
+	Tags     []string
+	Author   string
+	Keywords []string
+}
+
+func parseVacancyQuery(r *http.Request) (vacancyQuery, error) {
+	query := r.URL.Query()
+	q := vacancyQuery{
+		Tags:     query["tag"],
+		Author:   strings.TrimPrefix(query.Get("author"), "@"),
+		Keywords: strings.Fields(strings.ToLower(query.Get("q"))),
+	}
+	var err error
+	if v := query.Get("from"); v != "" {
+		if q.From, err = parseAPITime(v, false); err != nil {
+			return q, err
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if q.To, err = parseAPITime(v, true); err != nil {
+			return q, err
+		}
+	}
+	return q, nil
+}
+
+func parseAPITime(s string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(dateLayout, s); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, errors.New("Wrong time " + s + ", use 2006-01-02 or RFC 3339")
+	}
+	return t, nil
+}
+
+func (q vacancyQuery) matches(p posting) bool {
+	if q.Author != "" && !strings.EqualFold(p.Author, q.Author) {
+		return false
+	}
+	s := subscription{Tags: q.Tags, Keywords: q.Keywords}
+	return s.matches(p)
+}
+
+// vacancies returns postings matching the query, newest first.
+func (b *Bot) vacancies(q vacancyQuery) ([]vacancy, error) {
+	to := q.To
+	if to.IsZero() {
+		to = b.now().Add(time.Minute)
+	}
+	postings, err := listPostings(q.From, to, b.store)
+	if err != nil {
+		return nil, err
+	}
+	var result []vacancy
+	for i := len(postings) - 1; i >= 0; i-- {
+		if q.matches(postings[i]) {
+			result = append(result, vacancy{ID: postingID(postings[i].Text), posting: postings[i]})
+		}
+	}
+	return result, nil
+}
+
+// apiHandler serves:
+//
+//	GET /api/v1/vacancies?from=&to=&tag=&author=&q=&limit=&offset=
+//	GET /api/v1/vacancies/<id>
+//	GET /api/v1/counts?by=tag|author|company|channel|day|month and the filters
+func (b *Bot) apiHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		if token := b.cfg.API.Token; token != "" {
+			given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				writeAPIError(w, http.StatusUnauthorized, "Wrong token")
+				return
+			}
+		}
+		path := strings.TrimPrefix(r.URL.Path, apiPrefix)
+		switch {
+		case path == "vacancies":
+			b.listVacancies(w, r)
+		case strings.HasPrefix(path, "vacancies/"):
+			b.getVacancy(w, strings.TrimPrefix(path, "vacancies/"))
+		case path == "counts":
+			b.countVacancies(w, r)
+		default:
+			writeAPIError(w, http.StatusNotFound, "Not found")
+		}
+	}
+}
+
+func (b *Bot) listVacancies(w http.ResponseWriter, r *http.Request) {
+	q, err := parseVacancyQuery(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit, offset := defaultAPIPageSize, 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxAPIPageSize {
+			writeAPIError(w, http.StatusBadRequest, "Wrong limit "+v+", use 1 to "+strconv.Itoa(maxAPIPageSize))
+			return
+		}
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			writeAPIError(w, http.StatusBadRequest, "Wrong offset "+v)
+			return
+		}
+	}
+	all, err := b.vacancies(q)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "Can't read postings: "+err.Error())
+		return
+	}
+	page := []vacancy{}
+	if offset < len(all) {
+		end := offset + limit
+		if end > len(all) {
+			end = len(all)
+		}
+		page = all[offset:end]
+	}
+	writeJSON(w, map[string]interface{}{
+		"items":  page,
+		"total":  len(all),
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+func (b *Bot) getVacancy(w http.ResponseWriter, id string) {
+	p, err := findPostingByID(id, b.store)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "Can't read postings: "+err.Error())
+		return
+	}
+	if p == nil {
+		writeAPIError(w, http.StatusNotFound, "No vacancy "+id)
+		return
+	}
+	writeJSON(w, vacancy{ID: id, posting: *p})
+}
+
+// countKeys returns the groups a posting is counted in.
+var countKeys = map[string]func(p posting) []string{
+	"tag": func(p posting) []string {
+		if len(p.Tags) == 0 {
+			return []string{untagged}
+		}
+		return p.Tags
+	},
+	"author":  func(p posting) []string { return []string{p.Author} },
+	"company": func(p posting) []string { return []string{p.Fields.Company} },
+	"channel": func(p posting) []string { return []string{p.Channel} },
+	"day":     func(p posting) []string { return []string{p.Time.UTC().Format(dateLayout)} },
+	"month":   func(p posting) []string { return []string{p.Time.UTC().Format("2006-01")} },
+}
+
+type apiCount struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+func (b *Bot) countVacancies(w http.ResponseWriter, r *http.Request) {
+	by := r.URL.Query().Get("by")
+	if by == "" {
+		by = "tag"
+	}
+	key, ok := countKeys[by]
+	if !ok {
+		writeAPIError(w, http.StatusBadRequest, "Wrong by "+by+", use tag, author, company, channel, day or month")
+		return
+	}
+	q, err := parseVacancyQuery(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	all, err := b.vacancies(q)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "Can't read postings: "+err.Error())
+		return
+	}
+	counts := make(map[string]int)
+	for _, v := range all {
+		for _, k := range key(v.posting) {
+			if k != "" {
+				counts[k]++
+			}
+		}
+	}
+	result := []apiCount{}
+	for k, n := range counts {
+		result = append(result, apiCount{Key: k, Count: n})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if by != "day" && by != "month" && result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Key < result[j].Key
+	})
+	writeJSON(w, map[string]interface{}{
+		"by":     by,
+		"total":  len(all),
+		"counts": result,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
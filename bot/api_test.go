@@ -0,0 +1,166 @@
+package bot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newAPIBot(t *testing.T, cfg *API) (*Bot, func()) {
+	db := openTestDB(t)
+	now := time.Date(2018, 3, 20, 10, 0, 0, 0, time.UTC)
+	for _, p := range []posting{
+		{Text: "Manual QA in Yandex", Author: "vasya", Channel: "C1", Tags: []string{"manual"}, Fields: fields{Company: "yandex.ru"}, Time: time.Date(2018, 2, 10, 9, 0, 0, 0, time.UTC)},
+		{Text: "SDET, Selenium", Author: "petya", Channel: "C1", Tags: []string{"automation"}, Time: time.Date(2018, 3, 1, 9, 0, 0, 0, time.UTC)},
+		{Text: "Mobile SDET, Appium", Author: "vasya", Channel: "C2", Tags: []string{"automation", "mobile"}, Fields: fields{Company: "yandex.ru"}, Time: time.Date(2018, 3, 15, 9, 0, 0, 0, time.UTC)},
+	} {
+		if err := savePosted(p.Text, p, db); err != nil {
+			t.Fatal("Can't save posting: ", err)
+		}
+	}
+	b := newTestBot(t, newFakeSlack(), db, Config{API: cfg})
+	b.now = func() time.Time { return now }
+	return b, func() { closeTestDB(db) }
+}
+
+func getAPI(b *Bot, path string, out interface{}) int {
+	w := httptest.NewRecorder()
+	b.Handler().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	json.Unmarshal(w.Body.Bytes(), out)
+	return w.Code
+}
+
+type vacancyPage struct {
+	Items  []vacancy `json:"items"`
+	Total  int       `json:"total"`
+	Limit  int       `json:"limit"`
+	Offset int       `json:"offset"`
+	Error  string    `json:"error"`
+}
+
+func TestListVacancies(t *testing.T) {
+	b, cleanup := newAPIBot(t, &API{})
+	defer cleanup()
+
+	cases := []struct {
+		query  string
+		status int
+		texts  []string
+		total  int
+	}{
+		{"", http.StatusOK, []string{"Mobile SDET, Appium", "SDET, Selenium", "Manual QA in Yandex"}, 3},
+		{"?limit=1&offset=1", http.StatusOK, []string{"SDET, Selenium"}, 3},
+		{"?offset=5", http.StatusOK, nil, 3},
+		{"?tag=automation&author=@vasya", http.StatusOK, []string{"Mobile SDET, Appium"}, 1},
+		{"?from=2018-03-01&to=2018-03-01", http.StatusOK, []string{"SDET, Selenium"}, 1},
+		{"?to=2018-03-01T00:00:00Z", http.StatusOK, []string{"Manual QA in Yandex"}, 1},
+		{"?q=sdet+appium", http.StatusOK, []string{"Mobile SDET, Appium"}, 1},
+		{"?from=yesterday", http.StatusBadRequest, nil, 0},
+		{"?limit=500", http.StatusBadRequest, nil, 0},
+	}
+	for _, v := range cases {
+		var page vacancyPage
+		status := getAPI(b, "/api/v1/vacancies"+v.query, &page)
+		var texts []string
+		for _, it := range page.Items {
+			texts = append(texts, it.Text)
+		}
+		if status != v.status || strings.Join(texts, "|") != strings.Join(v.texts, "|") || page.Total != v.total {
+			t.Errorf("For query %s, actual status: %d, items: %v, total: %d", v.query, status, texts, page.Total)
+		}
+		if status != http.StatusOK && page.Error == "" {
+			t.Errorf("For query %s, error isn't reported", v.query)
+		}
+	}
+}
+
+func TestGetVacancy(t *testing.T) {
+	b, cleanup := newAPIBot(t, &API{})
+	defer cleanup()
+
+	var page vacancyPage
+	getAPI(b, "/api/v1/vacancies?limit=1", &page)
+	id := page.Items[0].ID
+	if id != postingID("Mobile SDET, Appium") {
+		t.Fatalf("Actual ID: %s", id)
+	}
+	var v vacancy
+	if status := getAPI(b, "/api/v1/vacancies/"+id, &v); status != http.StatusOK || v.Text != "Mobile SDET, Appium" || v.Author != "vasya" || len(v.Tags) != 2 {
+		t.Errorf("Actual status: %d, vacancy: %+v", status, v)
+	}
+	if status := getAPI(b, "/api/v1/vacancies/unknown", &v); status != http.StatusNotFound {
+		t.Errorf("Actual status for unknown ID: %d", status)
+	}
+}
+
+func TestCountVacancies(t *testing.T) {
+	b, cleanup := newAPIBot(t, &API{})
+	defer cleanup()
+
+	cases := []struct {
+		query, counts string
+	}{
+		{"", "automation=2 manual=1 mobile=1"},
+		{"?by=author", "vasya=2 petya=1"},
+		{"?by=company", "yandex.ru=2"},
+		{"?by=month", "2018-02=1 2018-03=2"},
+		{"?by=day&from=2018-03-01", "2018-03-01=1 2018-03-15=1"},
+	}
+	for _, v := range cases {
+		var reply struct {
+			Counts []apiCount `json:"counts"`
+		}
+		if status := getAPI(b, "/api/v1/counts"+v.query, &reply); status != http.StatusOK {
+			t.Fatalf("For query %s, actual status: %d", v.query, status)
+		}
+		var counts []string
+		for _, c := range reply.Counts {
+			counts = append(counts, c.Key+"="+strconv.Itoa(c.Count))
+		}
+		if strings.Join(counts, " ") != v.counts {
+			t.Errorf("For query %s, actual counts: %v, expected: %s", v.query, counts, v.counts)
+		}
+	}
+	var reply vacancyPage
+	if status := getAPI(b, "/api/v1/counts?by=salary", &reply); status != http.StatusBadRequest {
+		t.Errorf("Actual status for wrong grouping: %d", status)
+	}
+}
+
+func TestAPIAccess(t *testing.T) {
+	b, cleanup := newAPIBot(t, &API{Token: "secret"})
+	defer cleanup()
+
+	cases := []struct {
+		method, path, auth string
+		status             int
+	}{
+		{"GET", "/api/v1/vacancies", "Bearer secret", http.StatusOK},
+		{"GET", "/api/v1/vacancies", "", http.StatusUnauthorized},
+		{"GET", "/api/v1/vacancies", "Bearer wrong", http.StatusUnauthorized},
+		{"POST", "/api/v1/vacancies", "Bearer secret", http.StatusMethodNotAllowed},
+		{"GET", "/api/v1/unknown", "Bearer secret", http.StatusNotFound},
+	}
+	for _, v := range cases {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(v.method, v.path, nil)
+		r.Header.Set("Authorization", v.auth)
+		b.Handler().ServeHTTP(w, r)
+		if w.Code != v.status {
+			t.Errorf("For %s %s with %q, actual status: %d", v.method, v.path, v.auth, w.Code)
+		}
+	}
+
+	db := openTestDB(t)
+	defer closeTestDB(db)
+	off := newTestBot(t, newFakeSlack(), db, Config{})
+	w := httptest.NewRecorder()
+	off.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/vacancies", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("API isn't configured, actual status: %d", w.Code)
+	}
+}
@@ -0,0 +1,528 @@
+// Package bot reposts job postings between channels of Slack workspaces.
+// A Bot is created with NewBot for every workspace and fed with events of
+// its RTM connection by Run; the qa-slack-bot command wires it to flags.
+package bot
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/nlopes/slack"
+)
+
+var (
+	textKeywords = []string{"ваканси", "работа", "позици", "тестировщик", "автоматизатор", "должность", "требования"}
+	linkKeywords = []string{"hh.ru", "job", "linkedin.com/jobs", "position", "vacancy", "work", "career"}
+	exclusions   = []string{".slack.com", "linkedin.com/comm/profile", "linkedin.com/profile"}
+)
+
+const (
+	regexURL               = "(http|https)://([\\w_-]+(?:(?:\\.[\\w_-]+)+))([\\w.,@?^=%&:/~+#-]*[\\w@?^=%&/~+#-])?"
+	regexEmail             = "([a-zA-Z0-9][-_.a-zA-Z0-9]*)(@[-_.a-zA-Z0-9]+)"
+	wrongChannelID         = "Wrong channel ID"
+	wrongUserID            = "Wrong user ID"
+	messageIsNotJobPosting = "Not job posting"
+	messageIsAlreadyPosted = "Already posted"
+	noRouteForCategory     = "No route for category"
+	invalidCredentials     = "Invalid credentials"
+
+	defaultUsersRefresh = time.Hour
+)
+
+// Config is everything a Bot needs to know besides its dependencies. The
+// routes part is read from the JSON config file.
+type Config struct {
+	Routes     []*Route    `json:"routes"`
+	Categories []*Category `json:"categories"`
+	Digest     *Digest     `json:"digest"`
+	Webhooks   []*Webhook  `json:"webhooks"`
+	Feed       *Feed       `json:"feed"`
+	API        *API        `json:"api"`
+
+	// BotName is the Slack user the bot posts as, its own messages are
+	// never deleted or answered.
+	BotName           string        `json:"-"`
+	AlertsPerDay      int           `json:"-"`
+	UsersRefresh      time.Duration `json:"-"`
+	Workers           int           `json:"-"`
+	QueueSize         int           `json:"-"`
+	VerificationToken string        `json:"-"`
+	// Workspace labels metrics of the bot when several bots run in one
+	// process.
+	Workspace string `json:"-"`
+}
+
+func (cfg *Config) compile() error {
+	// Routes and categories are copied, so bots given the same config
+	// don't share their compiled patterns and resolved channels.
+	routes := make([]*Route, len(cfg.Routes))
+	for i, rt := range cfg.Routes {
+		copied := *rt
+		copied.External = make([]*Destination, len(rt.External))
+		for j, d := range rt.External {
+			destination := *d
+			copied.External[j] = &destination
+		}
+		routes[i] = &copied
+	}
+	cfg.Routes = routes
+	categories := make([]*Category, len(cfg.Categories))
+	for i, c := range cfg.Categories {
+		copied := *c
+		categories[i] = &copied
+	}
+	cfg.Categories = categories
+	for _, rt := range cfg.Routes {
+		if err := rt.compile(); err != nil {
+			return errors.New("Can't compile route " + rt.Name + ": " + err.Error())
+		}
+	}
+	for _, c := range cfg.Categories {
+		if err := c.compile(); err != nil {
+			return errors.New("Can't compile category " + c.Name + ": " + err.Error())
+		}
+	}
+	if cfg.Digest != nil {
+		if err := cfg.Digest.compile(); err != nil {
+			return errors.New("Can't compile digest: " + err.Error())
+		}
+	}
+	if cfg.Feed != nil {
+		if err := cfg.Feed.compile(); err != nil {
+			return errors.New("Can't compile feed: " + err.Error())
+		}
+	}
+	if cfg.API != nil {
+		if err := cfg.API.compile(); err != nil {
+			return errors.New("Can't compile api: " + err.Error())
+		}
+	}
+	names := make(map[string]bool)
+	for _, w := range cfg.Webhooks {
+		if err := w.compile(); err != nil {
+			return errors.New("Can't compile webhook " + w.Name + ": " + err.Error())
+		}
+		if names[w.Name] {
+			return errors.New("Webhook " + w.Name + " is defined twice")
+		}
+		names[w.Name] = true
+	}
+	return nil
+}
+
+// Deps are the services a Bot works with. Dedup, Connect, Now and Logger
+// are optional. Dedup is a DB shared by bots of several workspaces, a text
+// reposted by one of them isn't reposted by the others. Connect creates
+// clients of other workspaces for external destinations.
+type Deps struct {
+	Slack   Slacker
+	Store   *bolt.DB
+	Dedup   *bolt.DB
+	Connect func(token string) Slacker
+	Now     func() time.Time
+	Logger  *slog.Logger
+}
+
+// Bot reposts job postings between channels of one workspace and owns all
+// state needed for it, so several bots can run in one process.
+type Bot struct {
+	cfg    Config
+	client Slacker
+	store  *bolt.DB
+	dedup  *bolt.DB
+	now    func() time.Time
+	log    *slog.Logger
+
+	users     *userCache
+	channels  map[string]string
+	selfID    string
+	teamURL   string
+	connected int32
+	messages  *dispatcher
+	// fetched is what Check got from Slack, Init uses it instead of
+	// asking again.
+	fetched *workspaceState
+	// wake tells the outbox there are jobs due.
+	wake chan struct{}
+
+	eventsMu sync.Mutex
+	events   []event
+}
+
+// NewBot checks the config and creates the bot. Call Init before Run.
+func NewBot(cfg Config, deps Deps) (*Bot, error) {
+	if err := cfg.compile(); err != nil {
+		return nil, err
+	}
+	if err := createBuckets(deps.Store); err != nil {
+		return nil, errors.New("Can't create bucket: " + err.Error())
+	}
+	if deps.Dedup != nil {
+		if err := createBuckets(deps.Dedup); err != nil {
+			return nil, errors.New("Can't create bucket: " + err.Error())
+		}
+	}
+	if cfg.UsersRefresh <= 0 {
+		cfg.UsersRefresh = defaultUsersRefresh
+	}
+	b := &Bot{
+		cfg:      cfg,
+		client:   deps.Slack,
+		store:    deps.Store,
+		dedup:    deps.Dedup,
+		now:      deps.Now,
+		log:      deps.Logger,
+		channels: make(map[string]string),
+		wake:     make(chan struct{}, 1),
+	}
+	if b.now == nil {
+		b.now = time.Now
+	}
+	if b.log == nil {
+		b.log = slog.Default()
+	}
+	connect := deps.Connect
+	if connect == nil {
+		connect = func(token string) Slacker {
+			return NewSlackClient(token, slack.SLACK_API, nil)
+		}
+	}
+	for _, rt := range cfg.Routes {
+		for _, d := range rt.External {
+			if d.Token != "" {
+				d.client = connect(d.Token)
+			}
+		}
+	}
+	b.users = newUserCache(deps.Slack, b.log)
+	b.messages = newDispatcher(cfg.Workers, cfg.QueueSize, b.ProcessMessage)
+	return b, nil
+}
+
+// workspaceState is what the bot learns about the workspace on start.
+type workspaceState struct {
+	auth        *AuthInfo
+	authErr     error
+	usersErr    error
+	channels    ChannelList
+	channelsErr error
+}
+
+// fetch loads the user list and asks Slack for the token and the channels,
+// or returns what the last fetch got if Init hasn't used it yet.
+func (b *Bot) fetch() *workspaceState {
+	if b.fetched == nil {
+		w := &workspaceState{}
+		w.auth, w.authErr = b.client.AuthTest()
+		w.usersErr = b.users.Refresh()
+		w.channels, w.channelsErr = b.client.Channels()
+		b.fetched = w
+	}
+	return b.fetched
+}
+
+// Init loads users and channels of the workspace and resolves channel names
+// of the config. After Check it reuses what Check got from Slack.
+func (b *Bot) Init() error {
+	w := b.fetch()
+	b.fetched = nil
+	if w.usersErr != nil {
+		b.log.Error("Can't get list of users", "error", w.usersErr)
+	}
+	b.selfID, _ = b.users.IDByName(b.cfg.BotName)
+	if w.authErr != nil {
+		b.log.Error("Can't get workspace URL", "error", w.authErr)
+	} else {
+		b.teamURL = w.auth.URL
+	}
+	channels, err := w.channels, w.channelsErr
+	if err != nil {
+		return errors.New("Can't get list of channels: " + err.Error())
+	}
+	b.channels = channels.names()
+	for _, rt := range b.cfg.Routes {
+		if err := rt.resolve(channels); err != nil {
+			return errors.New("Can't resolve route " + rt.Name + ": " + err.Error())
+		}
+	}
+	for _, rt := range b.cfg.Routes {
+		for _, d := range rt.External {
+			if err := d.resolve(); err != nil {
+				return errors.New("Can't resolve destination " + externalKey(rt, d) + ": " + err.Error())
+			}
+		}
+	}
+	if b.cfg.Digest != nil {
+		if err := b.cfg.Digest.resolve(channels); err != nil {
+			return errors.New("Can't resolve digest channel: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Run handles RTM events until ctx is done or Slack rejects the
+// credentials. Before returning it finishes queued messages and stops
+// background jobs. A bot runs only once.
+func (b *Bot) Run(ctx context.Context, events <-chan slack.RTMEvent) error {
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	go b.users.RefreshEvery(b.cfg.UsersRefresh, stop)
+	go b.RunEvents(stop)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		b.RunOutbox(stop)
+	}()
+	if b.cfg.Digest != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.RunDigest(b.cfg.Digest, stop)
+		}()
+	}
+
+	err := b.serve(ctx, events)
+	b.messages.Stop()
+	close(stop)
+	wg.Wait()
+	b.flushEvents()
+	return err
+}
+
+// Handler serves slash commands, metrics, health checks and the feed and
+// the API if they are configured.
+func (b *Bot) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slack/command", b.slashCommandHandler(b.cfg.VerificationToken, b.statsReply))
+	mux.HandleFunc("/slack/search", b.slashCommandHandler(b.cfg.VerificationToken, b.searchReply))
+	mux.HandleFunc("/metrics", b.metricsHandler())
+	mux.HandleFunc("/healthz", b.healthHandler())
+	mux.HandleFunc("/readyz", b.readyHandler())
+	if b.cfg.Feed != nil {
+		mux.HandleFunc("/feed.rss", b.feedHandler("rss"))
+		mux.HandleFunc("/feed.atom", b.feedHandler("atom"))
+		mux.HandleFunc("/feed.json", b.feedHandler("json"))
+	}
+	if b.cfg.API != nil {
+		mux.HandleFunc(apiPrefix, b.apiHandler())
+	}
+	return mux
+}
+
+func (b *Bot) RepostMessage(ev *slack.MessageEvent) error {
+	var matched []*Route
+	for _, rt := range b.cfg.Routes {
+		if rt.isSource(ev.Channel) {
+			matched = append(matched, rt)
+		}
+	}
+	if len(matched) == 0 {
+		return errors.New(wrongChannelID)
+	}
+	if ev.SubType == "" {
+		b.recordEvent(eventSeen, ev.Channel, ev.User)
+	}
+	b.trackSource(ev)
+	if len(ev.Attachments) > 0 {
+		return errors.New(messageIsNotJobPosting)
+	}
+	text := ev.Text
+	if ev.SubMessage != nil && ev.SubMessage.Text != "" {
+		text = ev.SubMessage.Text
+	}
+
+	var jobRoutes []*Route
+	for _, rt := range matched {
+		if isJobPosting(text, &rt.Rules) {
+			jobRoutes = append(jobRoutes, rt)
+		}
+	}
+	if len(jobRoutes) == 0 {
+		return errors.New(messageIsNotJobPosting)
+	}
+	b.recordEvent(eventJob, ev.Channel, ev.User)
+	tags := tagPosting(text, b.cfg.Categories)
+	var taggedRoutes []*Route
+	for _, rt := range jobRoutes {
+		if rt.accepts(tags) {
+			taggedRoutes = append(taggedRoutes, rt)
+		}
+	}
+	if len(taggedRoutes) == 0 {
+		b.emit(webhookEvent{Event: eventJobSkipped, Reason: skipNoRoute, Channel: ev.Channel, Timestamp: ev.Timestamp, User: ev.User})
+		return errors.New(noRouteForCategory)
+	}
+	text = b.formatMessage(text)
+	if alreadyPosted(text, b.store) || pendingRepost(text, b.store) {
+		return b.duplicate(ev)
+	}
+	details := extractFields(text)
+	author, _ := b.users.Name(ev.User)
+	p := posting{
+		Text:      text,
+		Channel:   ev.Channel,
+		User:      ev.User,
+		Author:    author,
+		Timestamp: ev.Timestamp,
+		Tags:      tags,
+		Fields:    details,
+		Time:      b.now(),
+	}
+	if b.dedup != nil {
+		claimed, err := claimPosting(text, p, b.dedup)
+		if err != nil {
+			return errors.New("Can't claim posting in shared DB: " + err.Error())
+		}
+		if !claimed {
+			return b.duplicate(ev)
+		}
+	}
+
+	msg := repost{
+		Text:      text,
+		Author:    author,
+		Channel:   b.channels[ev.Channel],
+		Workspace: b.teamURL,
+		Tags:      tags,
+		Fields:    details,
+	}
+	var jobs []job
+	var err error
+	for _, rt := range taggedRoutes {
+		out, ferr := rt.format(msg)
+		if ferr != nil {
+			err = ferr
+			continue
+		}
+		for _, toID := range rt.toIDs {
+			jobs = append(jobs, job{Kind: jobRepost, Channel: toID, Text: out, Posting: &p})
+		}
+		for _, d := range rt.External {
+			if !d.accepts(p) {
+				continue
+			}
+			out, ferr := d.format(msg)
+			if ferr != nil {
+				err = ferr
+				continue
+			}
+			jobs = append(jobs, job{Kind: jobExternal, Destination: externalKey(rt, d), Text: out})
+		}
+	}
+	if len(jobs) == 0 {
+		b.release(p)
+		return err
+	}
+	jobs, qerr := enqueueRepost(text, jobs, b.now(), b.store)
+	if qerr != nil {
+		if qerr.Error() == messageIsAlreadyPosted {
+			// Another worker queued the same text after the check above.
+			b.recordEvent(eventDuplicate, ev.Channel, ev.User)
+		}
+		b.release(p)
+		return qerr
+	}
+	if derr := b.deliver(jobs); derr != nil {
+		err = derr
+	}
+	return err
+}
+
+func (b *Bot) duplicate(ev *slack.MessageEvent) error {
+	b.recordEvent(eventDuplicate, ev.Channel, ev.User)
+	b.emit(webhookEvent{Event: eventJobSkipped, Reason: skipDuplicate, Channel: ev.Channel, Timestamp: ev.Timestamp, User: ev.User})
+	return errors.New(messageIsAlreadyPosted)
+}
+
+// release gives up the claim of the posting in the shared DB, so the text
+// can be reposted from another workspace.
+func (b *Bot) release(p posting) {
+	if b.dedup == nil {
+		return
+	}
+	if err := releasePosting(p, b.dedup); err != nil {
+		b.log.Error("Can't release posting in shared DB", "error", err)
+	}
+}
+
+func (b *Bot) DeleteMessage(ev *slack.MessageEvent) error {
+	var target bool
+	for _, rt := range b.cfg.Routes {
+		if rt.isTarget(ev.Channel) {
+			target = true
+			break
+		}
+	}
+	if !target {
+		return errors.New(wrongChannelID)
+	}
+	if self := b.botUserID(); self == "" || ev.User == self {
+		return errors.New(wrongUserID)
+	}
+	jobs, err := enqueue([]job{{Kind: jobDelete, Channel: ev.Channel, Timestamp: ev.Timestamp, User: ev.User}}, b.now(), b.store)
+	if err != nil {
+		return err
+	}
+	return b.deliver(jobs)
+}
+
+// botUserID returns the ID of the bot user, looking it up again if it
+// wasn't known at start.
+func (b *Bot) botUserID() string {
+	if b.selfID != "" {
+		return b.selfID
+	}
+	id, _ := b.users.IDByName(b.cfg.BotName)
+	return id
+}
+
+func isJobPosting(text string, r *Rules) bool {
+	text = strings.ToLower(text)
+	withKeyword := containsKeyword(text, r.TextKeywords) || containsKeyword(text, r.LinkKeywords)
+	skypeRule := strings.HasPrefix(text, "[skype -")
+
+	var regexValidated bool
+	for _, rgxp := range r.regexps {
+		if rgxp.MatchString(text) {
+			regexValidated = true
+			break
+		}
+	}
+
+	var validatedText bool
+	if skypeRule {
+		validatedText = true
+	} else {
+		if regexValidated {
+			validatedText = withKeyword
+		}
+	}
+
+	return validatedText && validateExclusions(text, r.Exclusions)
+}
+
+func containsKeyword(text string, list []string) bool {
+	result := false
+	for _, v := range list {
+		if strings.Contains(text, v) {
+			result = true
+			break
+		}
+	}
+	return result
+}
+
+func validateExclusions(text string, list []string) bool {
+	for _, v := range list {
+		if strings.Contains(text, v) {
+			return false
+		}
+	}
+	return true
+}
@@ -1,7 +1,11 @@
-package main
+package bot
 
 import (
+	"errors"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"regexp"
 	"testing"
 	"time"
@@ -12,17 +16,6 @@ import (
 
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
-type testClient struct {
-}
-
-func (c testClient) Repost(toID, text string) error {
-	return nil
-}
-
-func (c testClient) Delete(toID, timestamp string) error {
-	return nil
-}
-
 func TestRegexp(t *testing.T) {
 	cases := []struct {
 		in  string
@@ -100,52 +93,17 @@ func TestIsJobPosting(t *testing.T) {
 		{"job job job linkedin.com/profile/fvfvf", false},
 	}
 
-	var regexList []*regexp.Regexp
-	r, _ := regexp.Compile(regexURL)
-	regexList = append(regexList, r)
-	r, _ = regexp.Compile(regexEmail)
-	regexList = append(regexList, r)
+	r := &Rules{}
+	r.compile()
 
 	for _, v := range cases {
-		result := isJobPosting(v.in, regexList)
+		result := isJobPosting(v.in, r)
 		if result != v.res {
 			t.Errorf("For string: %s, actual result: %v, expected: %v", v.in, result, v.res)
 		}
 	}
 }
 
-func TestReplaceID(t *testing.T) {
-	userMap = make(map[string]string)
-	userMap["U22KZA25S"] = "vasya"
-	userMap["U11KZA007"] = "aid"
-
-	cases := []struct {
-		in  string
-		out string
-	}{
-		{"", ""},
-		{"@U22KZA25S test", "@vasya test"},
-		{"@UQ1 test", "@UQ1 test"},
-		{"@UQ1001200 test", "@UQ1001200 test"},
-		{"test @U22KZA25S", "test @vasya"},
-		{"test @U22KZA25S test", "test @vasya test"},
-		{"@U22KZA25S test @U11KZA007", "@vasya test @aid"},
-		{"@U22KZA25S @U11KZA007", "@vasya @aid"},
-		{"@U22KZA25S@U11KZA007", "@vasya@aid"},
-		{"test @U22KZA25S test @U11KZA007", "test @vasya test @aid"},
-		{"test @U22KZA25S test @U11KZA007 test", "test @vasya test @aid test"},
-		{"@U22KZA25S test @U11KZA007 test", "@vasya test @aid test"},
-		{"@U22KZA25S test @U22KZA001", "@vasya test @U22KZA001"},
-	}
-
-	for _, v := range cases {
-		result := replaceIDWithNickname(v.in)
-		if result != v.out {
-			t.Errorf("For string: %s, actual result: %v, expected: %v", v.in, result, v.out)
-		}
-	}
-}
-
 func TestSlackClientRepost(t *testing.T) {
 	cases := []struct {
 		msg       *slack.MessageEvent
@@ -180,19 +138,12 @@ func TestSlackClientRepost(t *testing.T) {
 		}, messageIsNotJobPosting, "Correct text with attachments"},
 	}
 
-	fromID = "111"
-	var regexList []*regexp.Regexp
-	r, _ := regexp.Compile(regexURL)
-	regexList = append(regexList, r)
-	r, _ = regexp.Compile(regexEmail)
-	regexList = append(regexList, r)
-
-	client := &slackClient{
-		Client: testClient{},
-	}
+	db := openTestDB(t)
+	defer closeTestDB(db)
+	b := newTestBot(t, newFakeSlack(), db, Config{Routes: []*Route{testRoute("111", "333")}})
 
 	for _, v := range cases {
-		err := client.RepostMessage(v.msg, regexList)
+		err := b.RepostMessage(v.msg)
 		if err == nil {
 			t.Errorf("For case: %s, error shouldn't be nil!", v.desc)
 		}
@@ -209,10 +160,7 @@ func TestAlreadyPostedMessageShouldntBePostedTwice(t *testing.T) {
 		t.Fatal("Can't open DB: ", err)
 	}
 	defer db.Close()
-	err = db.Update(func(tx *bolt.Tx) error {
-		_, err = tx.CreateBucketIfNotExists([]byte(bucket))
-		return err
-	})
+	err = createBuckets(db)
 	if err != nil {
 		t.Fatal("Can't create bucket: ", err)
 	}
@@ -228,68 +176,43 @@ func TestAlreadyPostedMessageShouldntBePostedTwice(t *testing.T) {
 	}
 
 	// Prepare test data
-	fromID = "111"
-	var regexList []*regexp.Regexp
-	r, _ := regexp.Compile(regexURL)
-	regexList = append(regexList, r)
-	r, _ = regexp.Compile(regexEmail)
-	regexList = append(regexList, r)
-
 	ev := &slack.MessageEvent{
 		Msg: slack.Msg{
 			Channel: "111",
 			Text:    "test http://hh.ru",
 		},
 	}
-	client := &slackClient{
-		Client:  testClient{},
-		Storage: db,
-	}
+	b := newTestBot(t, newFakeSlack(), db, Config{Routes: []*Route{testRoute("111", "333")}})
 
-	err = client.RepostMessage(ev, regexList)
+	err = b.RepostMessage(ev)
 	if err == nil {
 		t.Error(err.Error())
 	}
 }
 
 func TestNewMessageShouldBeReposted(t *testing.T) {
-	userMap = make(map[string]string)
-	userMap["U11KZA007"] = "aid"
-
 	// Initialization of test DB
 	db, err := bolt.Open("test.db", 0600, nil)
 	if err != nil {
 		t.Fatal("Can't open DB: ", err)
 	}
 	defer db.Close()
-	err = db.Update(func(tx *bolt.Tx) error {
-		_, err = tx.CreateBucketIfNotExists([]byte(bucket))
-		return err
-	})
+	err = createBuckets(db)
 	if err != nil {
 		t.Fatal("Can't create bucket: ", err)
 	}
 
 	// Prepare test data
-	fromID = "111"
-	var regexList []*regexp.Regexp
-	r, _ := regexp.Compile(regexURL)
-	regexList = append(regexList, r)
-	r, _ = regexp.Compile(regexEmail)
-	regexList = append(regexList, r)
-
 	ev := &slack.MessageEvent{
 		Msg: slack.Msg{
 			Channel: "111",
-			Text:    "test @U11KZA007 http://hh.ru " + randomString(50),
+			Text:    "test <@U11KZA007> <http://hh.ru|hh.ru> " + randomString(50),
 		},
 	}
-	client := &slackClient{
-		Client:  testClient{},
-		Storage: db,
-	}
+	b := newTestBot(t, newFakeSlack(), db, Config{Routes: []*Route{testRoute("111", "333")}})
+	b.users.Set("U11KZA007", "aid")
 
-	err = client.RepostMessage(ev, regexList)
+	err = b.RepostMessage(ev)
 	if err != nil {
 		t.Error(err.Error())
 	}
@@ -297,24 +220,27 @@ func TestNewMessageShouldBeReposted(t *testing.T) {
 }
 
 func TestDeleteMessage(t *testing.T) {
-	toID = "111"
-	userID = "vasya"
+	client := newFakeSlack()
 	ev := &slack.MessageEvent{
 		Msg: slack.Msg{
-			Channel: "111",
-			User:    "kolya",
-			Text:    "flood",
+			Channel:   "111",
+			User:      "kolya",
+			Text:      "flood",
+			Timestamp: client.addMessage("111", "kolya", "flood"),
 		},
 	}
-	client := &slackClient{
-		Client: testClient{},
-	}
+	db := openTestDB(t)
+	defer closeTestDB(db)
+	b := newTestBot(t, client, db, Config{Routes: []*Route{testRoute("222", "111")}})
+	b.selfID = "vasya"
 
-	err := client.DeleteMessage(ev)
+	err := b.DeleteMessage(ev)
 	if err != nil {
 		t.Error("Can't delete message: ", err)
 	}
-
+	if msgs, _ := client.History("111", "", 10); len(msgs) != 0 {
+		t.Errorf("Message isn't deleted: %+v", msgs)
+	}
 }
 
 func TestDeleteMessageIncorrectValues(t *testing.T) {
@@ -337,20 +263,121 @@ func TestDeleteMessageIncorrectValues(t *testing.T) {
 		}, wrongUserID, "Wrong user ID"},
 	}
 
-	toID = "111"
-	userID = "vasya"
-	client := &slackClient{
-		Client: testClient{},
-	}
+	db := openTestDB(t)
+	defer closeTestDB(db)
+	b := newTestBot(t, newFakeSlack(), db, Config{Routes: []*Route{testRoute("222", "111")}})
+	b.selfID = "vasya"
 
 	for _, v := range cases {
-		err := client.DeleteMessage(v.msg)
+		err := b.DeleteMessage(v.msg)
 		if err != nil && err.Error() != v.res {
 			t.Errorf("For case: %s, actual error:%s, expected: %s", v.desc, err.Error(), v.res)
 		}
 	}
 }
 
+func TestBotsDontShareState(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+
+	client := newFakeSlack()
+	first := newTestBot(t, client, db, Config{Routes: []*Route{testRoute("111", "333")}})
+	second := newTestBot(t, newFakeSlack(), db, Config{Routes: []*Route{testRoute("222", "444")}})
+	first.users.Set("U1", "vasya")
+	second.selfID = "vasya"
+
+	if name, _ := second.users.Name("U1"); name == "vasya" {
+		t.Error("Users of one bot are visible to another")
+	}
+	ts := client.addMessage("333", "vasya", "flood")
+	err := first.DeleteMessage(&slack.MessageEvent{Msg: slack.Msg{Channel: "333", User: "vasya", Timestamp: ts}})
+	if err != nil {
+		t.Error("Bot user of one bot affects another: ", err)
+	}
+	err = second.RepostMessage(&slack.MessageEvent{Msg: slack.Msg{Channel: "111", Text: "http://hh.ru"}})
+	if err == nil || err.Error() != wrongChannelID {
+		t.Errorf("Routes of one bot are used by another, actual error: %v", err)
+	}
+}
+
+func TestBotInit(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+	client := newFakeSlack()
+	client.addChannel("C1", "general")
+	client.addChannel("G2", "jobs")
+	rt := &Route{Name: "default", From: []string{"general"}, To: []string{"jobs"}}
+	b, err := NewBot(Config{BotName: "bot", Routes: []*Route{rt}}, Deps{Slack: client, Store: db})
+	if err != nil {
+		t.Fatal("Can't create bot: ", err)
+	}
+
+	if err := b.Init(); err != nil {
+		t.Fatal("Can't init bot: ", err)
+	}
+	if b.selfID != fakeBotID || b.teamURL != "https://qa.slack.com/" {
+		t.Errorf("Actual bot user: %s, team URL: %s", b.selfID, b.teamURL)
+	}
+	if rt := b.cfg.Routes[0]; !rt.isSource("C1") || !rt.isTarget("G2") {
+		t.Errorf("Route isn't resolved: %v -> %v", rt.fromIDs, rt.toIDs)
+	}
+
+	client.fail("conversations.list", errors.New("timeout"))
+	if err := b.Init(); err == nil {
+		t.Error("Init should fail without channels")
+	}
+}
+
+// newTestBot creates a bot with the config that knows itself as UBOT.
+func newTestBot(t *testing.T, client Slacker, db *bolt.DB, cfg Config) *Bot {
+	b, err := NewBot(cfg, Deps{Slack: client, Store: db})
+	if err != nil {
+		t.Fatal("Can't create bot: ", err)
+	}
+	b.selfID = "UBOT"
+	return b
+}
+
+func testRoute(fromID, toID string) *Route {
+	rt := &Route{
+		Name: "test",
+		From: []string{"from"},
+		To:   []string{"to"},
+	}
+	rt.compile()
+	rt.fromIDs = []string{fromID}
+	rt.toIDs = []string{toID}
+	return rt
+}
+
+func openTestDB(t *testing.T) *bolt.DB {
+	dir, err := ioutil.TempDir("", "qa-slack-bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := bolt.Open(filepath.Join(dir, "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatal("Can't open DB: ", err)
+	}
+	if err := createBuckets(db); err != nil {
+		t.Fatal("Can't create bucket: ", err)
+	}
+	return db
+}
+
+func closeTestDB(db *bolt.DB) {
+	db.Close()
+	os.RemoveAll(filepath.Dir(db.Path()))
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
 func randomString(n int) string {
 	rand.Seed(time.Now().UnixNano())
 	b := make([]rune, n)
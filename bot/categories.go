@@ -0,0 +1,94 @@
+package bot
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Category tags a job posting when any of its keywords or patterns matches
+// the lowercased text.
+type Category struct {
+	Name     string   `json:"name"`
+	Keywords []string `json:"keywords"`
+	Patterns []string `json:"patterns"`
+
+	regexps []*regexp.Regexp
+}
+
+var defaultCategories = []Category{
+	{
+		Name:     "manual",
+		Keywords: []string{"manual", "ручно", "функциональн", "тест-кейс", "test case"},
+	},
+	{
+		Name:     "automation",
+		Keywords: []string{"automation", "автоматизац", "автоматизатор", "selenium", "webdriver", "appium", "cypress", "playwright", "selenide"},
+		Patterns: []string{`\bsdet\b`, `\baqa\b`},
+	},
+	{
+		Name:     "performance",
+		Keywords: []string{"performance", "нагрузочн", "производительност", "jmeter", "gatling", "load test", "locust"},
+	},
+	{
+		Name:     "security",
+		Keywords: []string{"security", "безопасност", "пентест", "pentest", "owasp", "секьюрити"},
+	},
+	{
+		Name:     "mobile",
+		Keywords: []string{"mobile", "мобильн", "android"},
+		Patterns: []string{`\bios\b`},
+	},
+	{
+		Name:     "management",
+		Keywords: []string{"qa lead", "test lead", "тимлид", "руководител", "head of qa", "qa manager", "test manager"},
+		Patterns: []string{`\blead\b`},
+	},
+}
+
+// DefaultCategories returns the categories used when the config has none.
+// Every call returns new values, since bots compile their categories.
+func DefaultCategories() []*Category {
+	result := make([]*Category, len(defaultCategories))
+	for i := range defaultCategories {
+		c := defaultCategories[i]
+		result[i] = &c
+	}
+	return result
+}
+
+func (c *Category) compile() error {
+	c.regexps = nil
+	for _, p := range c.Patterns {
+		rgxp, err := regexp.Compile(p)
+		if err != nil {
+			return err
+		}
+		c.regexps = append(c.regexps, rgxp)
+	}
+	return nil
+}
+
+func (c *Category) matches(text string) bool {
+	if containsKeyword(text, c.Keywords) {
+		return true
+	}
+	for _, rgxp := range c.regexps {
+		if rgxp.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// tagPosting returns names of all categories matching the text, in the
+// order they are configured.
+func tagPosting(text string, categories []*Category) []string {
+	text = strings.ToLower(text)
+	var tags []string
+	for _, c := range categories {
+		if c.matches(text) {
+			tags = append(tags, c.Name)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,79 @@
+package bot
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestTagPosting(t *testing.T) {
+	categories := DefaultCategories()
+	for _, c := range categories {
+		if err := c.compile(); err != nil {
+			t.Fatal("Can't compile category: ", err)
+		}
+	}
+
+	cases := []struct {
+		in  string
+		res []string
+	}{
+		{"Ищем ручного тестировщика http://hh.ru/1", []string{"manual"}},
+		{"Senior SDET, Selenium + Java http://example.com/jobs", []string{"automation"}},
+		{"Нагрузочное тестирование, JMeter http://hh.ru/2", []string{"performance"}},
+		{"Нужен секьюрити тестировщик, OWASP Top 10", []string{"security"}},
+		{"QA for iOS and Android apps, Appium http://hh.ru/3", []string{"automation", "mobile"}},
+		{"QA Lead position http://example.com/career", []string{"management"}},
+		{"Test scenarios and ratios http://hh.ru/4", nil},
+	}
+
+	for _, v := range cases {
+		result := tagPosting(v.in, categories)
+		if !reflect.DeepEqual(result, v.res) {
+			t.Errorf("For string: %s, actual result: %v, expected: %v", v.in, result, v.res)
+		}
+	}
+}
+
+func TestBotsDontShareCategories(t *testing.T) {
+	cfg := Config{Routes: []*Route{testRoute("111", "333")}, Categories: DefaultCategories()}
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		db := openTestDB(t)
+		defer closeTestDB(db)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := NewBot(cfg, Deps{Slack: newFakeSlack(), Store: db}); err != nil {
+				t.Error("Can't create bot: ", err)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, c := range append(cfg.Categories, DefaultCategories()...) {
+		if c.regexps != nil {
+			t.Errorf("Category %s of the config is compiled", c.Name)
+		}
+	}
+}
+
+func TestRouteAcceptsCategories(t *testing.T) {
+	cases := []struct {
+		categories, tags []string
+		res              bool
+	}{
+		{nil, nil, true},
+		{nil, []string{"manual"}, true},
+		{[]string{"automation"}, nil, false},
+		{[]string{"automation"}, []string{"manual"}, false},
+		{[]string{"automation", "performance"}, []string{"manual", "performance"}, true},
+	}
+
+	for _, v := range cases {
+		rt := &Route{Categories: v.categories}
+		result := rt.accepts(v.tags)
+		if result != v.res {
+			t.Errorf("For route: %v and tags: %v, actual result: %v, expected: %v", v.categories, v.tags, result, v.res)
+		}
+	}
+}
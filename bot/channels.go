@@ -0,0 +1,61 @@
+package bot
+
+import (
+	"errors"
+	"strings"
+)
+
+// Conversation is a public or private channel of the workspace, shared
+// channels included.
+type Conversation struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Private bool   `json:"is_private"`
+	Member  bool   `json:"is_member"`
+}
+
+// ChannelList is every conversation the bot can see. Private channels are
+// only visible to members.
+type ChannelList []Conversation
+
+// names maps IDs of the channels to their names.
+func (l ChannelList) names() map[string]string {
+	result := make(map[string]string)
+	for _, c := range l {
+		result[c.ID] = c.Name
+	}
+	return result
+}
+
+// find looks a channel up by ID, name or #name.
+func (l ChannelList) find(ref string) (Conversation, bool) {
+	for _, c := range l {
+		if c.ID == ref {
+			return c, true
+		}
+	}
+	name := strings.TrimPrefix(ref, "#")
+	for _, c := range l {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Conversation{}, false
+}
+
+// ids resolves channel references to IDs of channels the bot is a member
+// of, as it gets no events from and can't post to other channels.
+func (l ChannelList) ids(refs []string) ([]string, error) {
+	var result []string
+	for _, ref := range refs {
+		c, ok := l.find(ref)
+		if !ok {
+			return nil, errors.New("Can't find channel " + ref + ": it doesn't exist, is archived or is private and the bot isn't invited")
+		}
+		if !c.Member {
+			return nil, errors.New("Bot isn't a member of channel " + ref + ", invite it with /invite")
+		}
+		result = append(result, c.ID)
+	}
+	return result, nil
+}
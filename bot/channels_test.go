@@ -0,0 +1,39 @@
+package bot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChannelListIDs(t *testing.T) {
+	channels := ChannelList{
+		{ID: "C1", Name: "general", Member: true},
+		{ID: "C2", Name: "random"},
+		{ID: "G3", Name: "hiring", Private: true, Member: true},
+		{ID: "C4", Name: "shared-jobs", Member: true},
+	}
+	cases := []struct {
+		refs []string
+		ids  []string
+		err  string
+	}{
+		{[]string{"general", "#hiring", "C4"}, []string{"C1", "G3", "C4"}, ""},
+		{[]string{"G3"}, []string{"G3"}, ""},
+		{[]string{"general", "secret"}, nil, "Can't find channel secret"},
+		{[]string{"random"}, nil, "Bot isn't a member of channel random"},
+	}
+
+	for _, v := range cases {
+		ids, err := channels.ids(v.refs)
+		if v.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), v.err) {
+				t.Errorf("For channels: %v, actual error: %v, expected: %s", v.refs, err, v.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(ids, v.ids) {
+			t.Errorf("For channels: %v, actual result: %v, %v, expected: %v", v.refs, ids, err, v.ids)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultDigestPeriod = 7 * 24 * time.Hour
+	digestPreviewLength = 80
+	untagged            = "other"
+)
+
+// Digest posts a summary of reposts made during the last Period to
+// Channel every time Schedule fires.
+type Digest struct {
+	Schedule string `json:"schedule"`
+	Channel  string `json:"channel"`
+	Period   string `json:"period"`
+
+	schedule  *schedule
+	period    time.Duration
+	channelID string
+}
+
+func (d *Digest) compile() error {
+	var err error
+	d.schedule, err = parseSchedule(d.Schedule)
+	if err != nil {
+		return err
+	}
+	if d.Channel == "" {
+		return errors.New("digest needs a channel")
+	}
+	d.period = defaultDigestPeriod
+	if d.Period != "" {
+		d.period, err = ParsePeriod(d.Period)
+	}
+	return err
+}
+
+func (d *Digest) resolve(channels ChannelList) error {
+	ids, err := channels.ids([]string{d.Channel})
+	if err != nil {
+		return err
+	}
+	d.channelID = ids[0]
+	return nil
+}
+
+// RunDigest posts digests on schedule until stop is closed. A digest being
+// posted is finished first.
+func (b *Bot) RunDigest(d *Digest, stop <-chan struct{}) {
+	for {
+		next := d.schedule.next(b.now())
+		if next.IsZero() {
+			b.log.Error("Digest schedule never fires", "schedule", d.Schedule)
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if err := b.PostDigest(d, next); err != nil {
+			b.log.Error("Can't post digest", "channel", d.Channel, "error", err)
+		}
+	}
+}
+
+// PostDigest queues the digest of the period before now to the outbox,
+// unless there is nothing to post.
+func (b *Bot) PostDigest(d *Digest, now time.Time) error {
+	postings, err := listPostings(now.Add(-d.period), now, b.store)
+	if err != nil {
+		return err
+	}
+	if len(postings) == 0 {
+		return nil
+	}
+	return b.schedule([]job{{Kind: jobPost, Channel: d.channelID, Text: buildDigest(postings, now.Add(-d.period), now)}})
+}
+
+// buildDigest groups postings by category, biggest categories first. A
+// posting with several tags is listed under each of them.
+func buildDigest(postings []posting, from, to time.Time) string {
+	groups := make(map[string][]posting)
+	for _, p := range postings {
+		tags := p.Tags
+		if len(tags) == 0 {
+			tags = []string{untagged}
+		}
+		for _, tag := range tags {
+			groups[tag] = append(groups[tag], p)
+		}
+	}
+	var names []string
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if len(groups[names[i]]) != len(groups[names[j]]) {
+			return len(groups[names[i]]) > len(groups[names[j]])
+		}
+		return names[i] < names[j]
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*Vacancies from %s to %s: %d*\n", from.Format("02.01"), to.Format("02.01"), len(postings))
+	for _, name := range names {
+		fmt.Fprintf(&b, "\n*%s* - %d\n", name, len(groups[name]))
+		for _, p := range groups[name] {
+			fmt.Fprintf(&b, "• %s\n", digestLine(p))
+		}
+	}
+	return b.String()
+}
+
+func digestLine(p posting) string {
+	title := preview(p.Text)
+	if runes := []rune(title); len(runes) > digestPreviewLength {
+		title = string(runes[:digestPreviewLength]) + "…"
+	}
+	if p.Link == "" || strings.HasPrefix(p.Link, "<") {
+		return title
+	}
+	// Link labels can't contain markup, the rest is already escaped by Slack.
+	title = strings.NewReplacer("|", "/", "<", "", ">", "").Replace(title)
+	return "<" + p.Link + "|" + title + ">"
+}
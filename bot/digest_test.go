@@ -0,0 +1,85 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPostDigest(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+
+	now := time.Date(2018, time.March, 19, 10, 0, 0, 0, time.UTC)
+	for _, p := range []posting{
+		{Text: "Old vacancy", Tags: []string{"manual"}, Time: now.AddDate(0, 0, -8)},
+		{Text: "QA Automation, Selenium", Tags: []string{"automation"}, Link: "https://qa.slack.com/archives/C1/p1", Time: now.AddDate(0, 0, -3)},
+		{Text: "Mobile SDET\nsecond line", Tags: []string{"automation", "mobile"}, Link: "https://qa.slack.com/archives/C1/p2", Time: now.AddDate(0, 0, -2)},
+		{Text: "Tester | office", Time: now.AddDate(0, 0, -1)},
+	} {
+		savePosted(p.Text, p, db)
+	}
+
+	recorder := newFakeSlack()
+	b := newTestBot(t, recorder, db, Config{})
+	d := &Digest{Schedule: "0 10 * * 1", Channel: "jobs"}
+	if err := d.compile(); err != nil {
+		t.Fatal(err)
+	}
+	d.channelID = "C2"
+
+	recorder.fail("chat.postMessage", &rateLimitError{Method: "chat.postMessage", RetryAfter: time.Minute})
+	if err := b.PostDigest(d, now); err != nil {
+		t.Fatal("Can't post digest: ", err)
+	}
+	b.deliverDue()
+	if jobs, _ := listJobs(db); len(jobs) != 1 || len(recorder.posts()) != 0 {
+		t.Fatalf("Rate limited digest should wait in the outbox, actual jobs: %+v", jobs)
+	}
+	jobs, _ := claimJobs(b.now().Add(time.Hour), db)
+	b.deliver(jobs)
+	expected := "*Vacancies from 12.03 to 19.03: 3*\n" +
+		"\n*automation* - 2\n" +
+		"• <https://qa.slack.com/archives/C1/p1|QA Automation, Selenium>\n" +
+		"• <https://qa.slack.com/archives/C1/p2|Mobile SDET>\n" +
+		"\n*mobile* - 1\n" +
+		"• <https://qa.slack.com/archives/C1/p2|Mobile SDET>\n" +
+		"\n*other* - 1\n" +
+		"• Tester | office\n"
+	if posts := recorder.markdownPosts()["C2"]; len(posts) != 1 || posts[0] != expected {
+		t.Errorf("Actual digest: %q, expected: %q", strings.Join(posts, ""), expected)
+	}
+
+	empty := newFakeSlack()
+	b.client = empty
+	if err := b.PostDigest(d, now.AddDate(1, 0, 0)); err != nil {
+		t.Fatal("Can't post digest: ", err)
+	}
+	b.deliverDue()
+	if len(empty.posts()) != 0 {
+		t.Error("Empty digest shouldn't be posted")
+	}
+}
+
+func TestDigestPeriod(t *testing.T) {
+	cases := []struct {
+		in  string
+		res time.Duration
+		ok  bool
+	}{
+		{"", defaultDigestPeriod, true},
+		{"7d", 7 * 24 * time.Hour, true},
+		{"36h", 36 * time.Hour, true},
+		{"0d", 0, false},
+		{"-24h", 0, false},
+		{"week", 0, false},
+	}
+
+	for _, v := range cases {
+		d := &Digest{Schedule: "0 10 * * 1", Channel: "jobs", Period: v.in}
+		err := d.compile()
+		if (err == nil) != v.ok || v.ok && d.period != v.res {
+			t.Errorf("For period: %q, actual result: %v, %v, expected: %v", v.in, d.period, err, v.res)
+		}
+	}
+}
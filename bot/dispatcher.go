@@ -0,0 +1,71 @@
+package bot
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nlopes/slack"
+)
+
+// dispatcher processes messages on a fixed set of workers. All messages of
+// a channel go to the same worker, so they are handled in the order they
+// arrived, while channels don't wait for each other.
+type dispatcher struct {
+	queued int64
+	queues []chan *slack.MessageEvent
+	handle func(*slack.MessageEvent)
+	wg     sync.WaitGroup
+}
+
+// newDispatcher starts workers, each with a queue of queueSize messages.
+func newDispatcher(workers, queueSize int, handle func(*slack.MessageEvent)) *dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	d := &dispatcher{handle: handle}
+	for i := 0; i < workers; i++ {
+		queue := make(chan *slack.MessageEvent, queueSize)
+		d.queues = append(d.queues, queue)
+		d.wg.Add(1)
+		go d.work(queue)
+	}
+	return d
+}
+
+func (d *dispatcher) work(queue chan *slack.MessageEvent) {
+	defer d.wg.Done()
+	for ev := range queue {
+		d.handle(ev)
+		atomic.AddInt64(&d.queued, -1)
+	}
+}
+
+// Dispatch queues the message. When the queue of its channel is full it
+// blocks, which stops reading RTM events until the worker catches up.
+func (d *dispatcher) Dispatch(ev *slack.MessageEvent) {
+	h := fnv.New32a()
+	h.Write([]byte(ev.Channel))
+	queue := d.queues[h.Sum32()%uint32(len(d.queues))]
+	atomic.AddInt64(&d.queued, 1)
+	select {
+	case queue <- ev:
+	default:
+		metricQueueFull.Inc()
+		queue <- ev
+	}
+}
+
+// Queued returns the number of messages waiting for or being processed by
+// workers.
+func (d *dispatcher) Queued() int64 {
+	return atomic.LoadInt64(&d.queued)
+}
+
+// Stop waits until the queued messages are processed.
+func (d *dispatcher) Stop() {
+	for _, queue := range d.queues {
+		close(queue)
+	}
+	d.wg.Wait()
+}
@@ -0,0 +1,70 @@
+package bot
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+func TestDispatcherKeepsChannelOrder(t *testing.T) {
+	var mu sync.Mutex
+	handled := make(map[string][]string)
+	release := make(chan struct{})
+	var fast sync.WaitGroup
+	d := newDispatcher(4, 2, func(ev *slack.MessageEvent) {
+		if ev.Channel == "SLOW" {
+			<-release
+		}
+		mu.Lock()
+		handled[ev.Channel] = append(handled[ev.Channel], ev.Text)
+		mu.Unlock()
+		if ev.Channel == "C1" {
+			fast.Done()
+		}
+	})
+
+	d.Dispatch(&slack.MessageEvent{Msg: slack.Msg{Channel: "SLOW", Text: "0"}})
+	var expected []string
+	for i := 0; i < 10; i++ {
+		expected = append(expected, strconv.Itoa(i))
+		fast.Add(1)
+		d.Dispatch(&slack.MessageEvent{Msg: slack.Msg{Channel: "C1", Text: strconv.Itoa(i)}})
+	}
+	// Messages of other channels don't wait for the slow one.
+	fast.Wait()
+	close(release)
+	d.Stop()
+
+	if !reflect.DeepEqual(handled["C1"], expected) {
+		t.Errorf("Actual order: %v, expected: %v", handled["C1"], expected)
+	}
+	if len(handled["SLOW"]) != 1 {
+		t.Errorf("Queued message wasn't processed before stop")
+	}
+}
+
+func TestConcurrentDuplicatesArePostedOnce(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+
+	client := newFakeSlack()
+	rt := testRoute("C1", "C9")
+	rt.fromIDs = []string{"C1", "C2", "C3", "C4"}
+	b := newTestBot(t, client, db, Config{Routes: []*Route{rt}})
+	text := "Вакансия тестировщика https://hh.ru/vacancy/" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	d := newDispatcher(4, 1, func(ev *slack.MessageEvent) {
+		b.RepostMessage(ev)
+	})
+	for _, channel := range rt.fromIDs {
+		d.Dispatch(&slack.MessageEvent{Msg: slack.Msg{Channel: channel, User: "U1", Text: text}})
+	}
+	d.Stop()
+
+	if n := len(client.posts()["C9"]); n != 1 {
+		t.Errorf("Actual number of reposts: %d, expected: 1", n)
+	}
+}
@@ -0,0 +1,221 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	// Statuses of a CheckResult.
+	CheckPass = "PASS"
+	CheckWarn = "WARN"
+	CheckFail = "FAIL"
+
+	dbOpenTimeout = time.Second
+)
+
+var (
+	// Any of these scopes lets the token post, old bot tokens only have "bot".
+	postScopes = []string{"chat:write", "chat:write:bot", "chat:write:user", "bot", "client"}
+	// Messages of other users can only be deleted with a user token.
+	deleteScopes = []string{"chat:write:user", "client"}
+)
+
+// CheckResult is the outcome of one startup check.
+type CheckResult struct {
+	Name   string
+	Status string
+	Detail string
+}
+
+func pass(name, detail string) CheckResult {
+	return CheckResult{Name: name, Status: CheckPass, Detail: detail}
+}
+
+func warn(name, detail string) CheckResult {
+	return CheckResult{Name: name, Status: CheckWarn, Detail: detail}
+}
+
+func fail(name string, err error) CheckResult {
+	return CheckResult{Name: name, Status: CheckFail, Detail: err.Error()}
+}
+
+// Check verifies that the token works and belongs to the bot user, every
+// configured channel is found and has the bot as a member, and the token
+// may post and delete messages.
+func (b *Bot) Check() []CheckResult {
+	w := b.fetch()
+	auth, err := w.auth, w.authErr
+	if err != nil {
+		return []CheckResult{fail("token", err)}
+	}
+	results := []CheckResult{pass("token", "workspace "+auth.URL+" as @"+auth.User)}
+
+	if b.cfg.BotName != auth.User {
+		err := errors.New("token belongs to @" + auth.User + " but the bot user is @" + b.cfg.BotName + ", own reposts would be deleted")
+		results = append(results, fail("bot user", err))
+	} else {
+		results = append(results, pass("bot user", "@"+auth.User+" is "+auth.UserID))
+	}
+
+	if w.usersErr != nil {
+		results = append(results, fail("users", w.usersErr))
+	} else {
+		results = append(results, pass("users", "list of users loaded"))
+	}
+
+	channels, err := w.channels, w.channelsErr
+	if err != nil {
+		return append(results, fail("channels", err))
+	}
+	for _, rt := range b.cfg.Routes {
+		results = append(results, checkChannels("route "+rt.Name, channels, rt.From, rt.To))
+		for _, d := range rt.External {
+			results = append(results, checkDestination(externalKey(rt, d), d))
+		}
+	}
+	if b.cfg.Digest != nil {
+		results = append(results, checkChannels("digest", channels, []string{b.cfg.Digest.Channel}))
+	}
+	if b.cfg.VerificationToken == "" {
+		results = append(results, warn("slash commands", "no verification token, slash commands are refused"))
+	} else {
+		results = append(results, pass("slash commands", "requests are checked with the verification token"))
+	}
+	return append(results, checkScopes(auth.Scopes)...)
+}
+
+func checkChannels(name string, channels ChannelList, refs ...[]string) CheckResult {
+	var all []string
+	for _, r := range refs {
+		all = append(all, r...)
+	}
+	if _, err := channels.ids(all); err != nil {
+		return fail(name, err)
+	}
+	return pass(name, strconv.Itoa(len(all))+" channel(s) found, bot is a member")
+}
+
+func checkDestination(key string, d *Destination) CheckResult {
+	name := "destination " + key
+	if d.Webhook != "" {
+		return pass(name, "incoming webhook, can't be checked without posting")
+	}
+	if err := d.resolve(); err != nil {
+		return fail(name, err)
+	}
+	return pass(name, "channel "+d.Channel+" found, bot is a member")
+}
+
+func checkScopes(scopes []string) []CheckResult {
+	if len(scopes) == 0 {
+		return []CheckResult{warn("permissions", "Slack didn't report scopes of the token, can't check them")}
+	}
+	var results []CheckResult
+	if hasAny(scopes, postScopes) {
+		results = append(results, pass("post", "token may post messages"))
+	} else {
+		results = append(results, fail("post", errors.New("token has none of the scopes to post messages: chat:write, bot")))
+	}
+	if hasAny(scopes, deleteScopes) {
+		results = append(results, pass("delete", "token may delete messages"))
+	} else {
+		results = append(results, warn("delete", "token can't delete messages of other users in target channels, it needs a user token with chat:write:user"))
+	}
+	return results
+}
+
+func hasAny(list, values []string) bool {
+	for _, v := range values {
+		if contains(list, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// LogChecks logs failed checks and warnings and reports whether the bot
+// can start.
+func LogChecks(results []CheckResult, log *slog.Logger) bool {
+	ok := true
+	for _, r := range results {
+		switch r.Status {
+		case CheckFail:
+			ok = false
+			log.Error("Startup check failed", "check", r.Name, "error", r.Detail)
+		case CheckWarn:
+			log.Warn("Startup check", "check", r.Name, "warning", r.Detail)
+		}
+	}
+	return ok
+}
+
+// Doctor runs every check of the config, the DB and Slack, prints a
+// report and returns the exit code. dedupPath is the DB shared for dedup
+// with other workspaces, empty when there is none.
+func Doctor(cfg Config, client Slacker, dbPath, dedupPath string, w io.Writer) int {
+	var results []CheckResult
+	report := func() int {
+		code := 0
+		for _, r := range results {
+			fmt.Fprintf(w, "%s  %s: %s\n", r.Status, r.Name, r.Detail)
+			if r.Status == CheckFail {
+				code = 1
+			}
+		}
+		return code
+	}
+
+	if err := cfg.compile(); err != nil {
+		results = append(results, fail("config", err))
+		return report()
+	}
+	results = append(results, pass("config", strconv.Itoa(len(cfg.Routes))+" route(s) compiled"))
+
+	db, result := checkDB("db", dbPath)
+	results = append(results, result)
+	if db == nil {
+		return report()
+	}
+	defer db.Close()
+	var dedup *bolt.DB
+	if dedupPath != "" {
+		dedup, result = checkDB("dedup db", dedupPath)
+		results = append(results, result)
+		if dedup == nil {
+			return report()
+		}
+		defer dedup.Close()
+	}
+
+	b, err := NewBot(cfg, Deps{Slack: client, Store: db, Dedup: dedup, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	if err != nil {
+		results = append(results, fail("bot", err))
+		return report()
+	}
+	results = append(results, b.Check()...)
+	return report()
+}
+
+// checkDB opens the DB at path and checks that it is writable. The DB is
+// nil when the check fails.
+func checkDB(name, path string) (*bolt.DB, CheckResult) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: dbOpenTimeout})
+	if err == bolt.ErrTimeout {
+		err = errors.New("DB is locked, stop the bot to check it")
+	}
+	if err != nil {
+		return nil, fail(name, err)
+	}
+	if err := dbWritable(db); err != nil {
+		db.Close()
+		return nil, fail(name, err)
+	}
+	return db, pass(name, path+" is writable")
+}
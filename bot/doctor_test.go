@@ -0,0 +1,133 @@
+package bot
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestBotCheck(t *testing.T) {
+	cases := []struct {
+		desc    string
+		botName string
+		scopes  []string
+		setup   func(f *fakeSlack)
+		checks  map[string]string
+	}{
+		{"All good", "bot", []string{"chat:write:user", "users:read"}, func(f *fakeSlack) {},
+			map[string]string{"token": CheckPass, "bot user": CheckPass, "users": CheckPass, "route jobs": CheckPass, "post": CheckPass, "delete": CheckPass, "slash commands": CheckWarn}},
+		{"Bot token", "bot", []string{"bot"}, func(f *fakeSlack) {},
+			map[string]string{"post": CheckPass, "delete": CheckWarn}},
+		{"No scopes", "bot", nil, func(f *fakeSlack) {},
+			map[string]string{"permissions": CheckWarn}},
+		{"No post scope", "bot", []string{"users:read"}, func(f *fakeSlack) {},
+			map[string]string{"post": CheckFail}},
+		{"Token of another user", "qa-bot", []string{"bot"}, func(f *fakeSlack) {},
+			map[string]string{"token": CheckPass, "bot user": CheckFail}},
+		{"Not a member", "bot", []string{"bot"}, func(f *fakeSlack) { f.channels[1].Member = false },
+			map[string]string{"route jobs": CheckFail}},
+		{"Users can't be listed", "bot", []string{"bot"}, func(f *fakeSlack) { f.fail("users.list", &apiError{Method: "users.list", Code: "missing_scope"}) },
+			map[string]string{"users": CheckFail, "route jobs": CheckPass}},
+		{"Invalid token", "bot", nil, func(f *fakeSlack) { f.fail("auth.test", &apiError{Method: "auth.test", Code: "invalid_auth"}) },
+			map[string]string{"token": CheckFail}},
+	}
+
+	for _, v := range cases {
+		db := openTestDB(t)
+		client := newFakeSlack()
+		client.addChannel("C1", "general")
+		client.addChannel("C2", "jobs")
+		client.scopes = v.scopes
+		v.setup(client)
+		rt := &Route{Name: "jobs", From: []string{"general"}, To: []string{"jobs"}}
+		b := newTestBot(t, client, db, Config{BotName: v.botName, Routes: []*Route{rt}})
+
+		results := make(map[string]string)
+		for _, r := range b.Check() {
+			results[r.Name] = r.Status
+		}
+		for name, status := range v.checks {
+			if results[name] != status {
+				t.Errorf("For case: %s, check %s actual status: %s, expected: %s", v.desc, name, results[name], status)
+			}
+		}
+		closeTestDB(db)
+	}
+}
+
+func TestInitAfterCheck(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+	client := newFakeSlack()
+	client.addChannel("C1", "general")
+	client.addChannel("C2", "jobs")
+	b := newTestBot(t, client, db, Config{BotName: "bot", Routes: []*Route{{Name: "jobs", From: []string{"general"}, To: []string{"jobs"}}}})
+
+	b.Check()
+	if err := b.Init(); err != nil {
+		t.Fatal("Can't init bot: ", err)
+	}
+	for _, method := range []string{"auth.test", "users.list", "conversations.list"} {
+		if client.calls[method] != 1 {
+			t.Errorf("For method: %s, actual calls: %d, expected: 1", method, client.calls[method])
+		}
+	}
+	if b.teamURL != "https://qa.slack.com/" || !b.cfg.Routes[0].isSource("C1") {
+		t.Errorf("Init didn't use the results of Check: %s, %v", b.teamURL, b.cfg.Routes[0].fromIDs)
+	}
+}
+
+func TestRunDoctor(t *testing.T) {
+	client := newFakeSlack()
+	client.addChannel("C1", "general")
+	client.addChannel("C2", "jobs")
+	client.scopes = []string{"chat:write:user"}
+	dbPath := filepath.Join(t.TempDir(), "doctor.db")
+	cfg := func() Config {
+		return Config{BotName: "bot", Routes: []*Route{{Name: "jobs", From: []string{"general"}, To: []string{"jobs"}}}}
+	}
+
+	var out bytes.Buffer
+	if code := Doctor(cfg(), client, dbPath, "", &out); code != 0 {
+		t.Errorf("Actual exit code: %d, expected: 0, report:\n%s", code, out.String())
+	}
+	for _, line := range []string{"PASS  config:", "PASS  db:", "PASS  token: workspace https://qa.slack.com/ as @bot", "PASS  route jobs:"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Report doesn't contain %q:\n%s", line, out.String())
+		}
+	}
+
+	out.Reset()
+	broken := cfg()
+	broken.Routes[0].Format = "{{.Text"
+	if code := Doctor(broken, client, dbPath, "", &out); code != 1 || !strings.HasPrefix(out.String(), "FAIL  config:") {
+		t.Errorf("Actual exit code: %d, report:\n%s", code, out.String())
+	}
+
+	out.Reset()
+	dedupPath := filepath.Join(t.TempDir(), "dedup.db")
+	if code := Doctor(cfg(), client, dbPath, dedupPath, &out); code != 0 || !strings.Contains(out.String(), "PASS  dedup db: "+dedupPath+" is writable") {
+		t.Errorf("Actual exit code: %d, report:\n%s", code, out.String())
+	}
+
+	out.Reset()
+	shared, err := bolt.Open(dedupPath, 0600, nil)
+	if err != nil {
+		t.Fatal("Can't open dedup DB: ", err)
+	}
+	code := Doctor(cfg(), client, dbPath, dedupPath, &out)
+	shared.Close()
+	if code != 1 || !strings.Contains(out.String(), "FAIL  dedup db: DB is locked") {
+		t.Errorf("Actual exit code: %d, report:\n%s", code, out.String())
+	}
+
+	out.Reset()
+	client.fail("conversations.list", errors.New("timeout"))
+	if code := Doctor(cfg(), client, dbPath, "", &out); code != 1 || !strings.Contains(out.String(), "FAIL  channels: timeout") {
+		t.Errorf("Actual exit code: %d, report:\n%s", code, out.String())
+	}
+}
@@ -0,0 +1,294 @@
+package bot
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// fields are the structured details extracted from a free text vacancy.
+type fields struct {
+	SalaryMin  int      `json:"salary_min,omitempty"`
+	SalaryMax  int      `json:"salary_max,omitempty"`
+	Currency   string   `json:"currency,omitempty"`
+	Locations  []string `json:"locations,omitempty"`
+	Remote     bool     `json:"remote,omitempty"`
+	Relocation bool     `json:"relocation,omitempty"`
+	Seniority  []string `json:"seniority,omitempty"`
+	Employment []string `json:"employment,omitempty"`
+	Stack      []string `json:"stack,omitempty"`
+	Company    string   `json:"company,omitempty"`
+}
+
+type term struct {
+	name string
+	re   *regexp.Regexp
+}
+
+const (
+	wordStart = `(?:^|[^\p{L}\p{N}_])`
+	wordEnd   = `(?:$|[^\p{L}\p{N}_])`
+	number    = `(\d{1,3}(?:[ \x{00a0},]\d{3})+|\d+(?:[.,]\d+)?)`
+	// A multiplier is only accepted when it isn't followed by a letter,
+	// which is checked in code as Go regexps have no lookahead.
+	multiplier = `(k|к|тыс\.?|thousands?)?`
+	currency   = `(\$|€|₽|рублей|руб\.?|р\.|usd|euro|eur|евро|долларов|dollars?)?`
+	rangeSep   = `(?:-|–|—|до|to)`
+)
+
+var (
+	domainRe    = regexp.MustCompile(`(?:https?://(?:www\.)?|[a-z0-9._%+-]@)([a-z0-9-]+(?:\.[a-z0-9-]+)*\.[a-z]{2,})`)
+	thousandsRe = regexp.MustCompile(`^\d{1,3}(?:[ \x{00a0},]\d{3})+$`)
+	salaryRe    = regexp.MustCompile(`(от|from|до|up to)?\s*(\$|€|₽)?\s*` + number + `\s*` + multiplier +
+		`(?:\s*` + rangeSep + `\s*(?:\$|€|₽)?\s*` + number + `\s*` + multiplier + `)?\s*` + currency)
+
+	currencies = map[string]string{
+		"$": "USD", "usd": "USD", "dollar": "USD", "dollars": "USD", "долларов": "USD",
+		"€": "EUR", "eur": "EUR", "euro": "EUR", "евро": "EUR",
+		"₽": "RUB", "руб": "RUB", "руб.": "RUB", "рублей": "RUB", "р.": "RUB",
+	}
+
+	locationTerms = terms(map[string]string{
+		"Moscow":           `москв\p{L}*|moscow|мск`,
+		"Saint Petersburg": `санкт-петербург\p{L}*|петербург\p{L}*|спб|питер\p{L}*|saint petersburg|st\.? petersburg`,
+		"Novosibirsk":      `новосибирск\p{L}*|novosibirsk`,
+		"Yekaterinburg":    `екатеринбург\p{L}*|yekaterinburg`,
+		"Kazan":            `казан\p{L}*|kazan`,
+		"Nizhny Novgorod":  `нижн\p{L}* новгород\p{L}*|nizhny novgorod`,
+		"Minsk":            `минск\p{L}*|minsk`,
+		"Kyiv":             `киев\p{L}*|ки[їi]в\p{L}*|kyiv|kiev`,
+		"Almaty":           `алмат\p{L}*|almaty`,
+		"Tbilisi":          `тбилиси|tbilisi`,
+		"Belgrade":         `белград\p{L}*|belgrade`,
+		"Limassol":         `лимассол\p{L}*|limassol`,
+		"Warsaw":           `варшав\p{L}*|warsaw`,
+		"Berlin":           `берлин\p{L}*|berlin`,
+		"Amsterdam":        `амстердам\p{L}*|amsterdam`,
+		"London":           `лондон\p{L}*|london`,
+		"Russia":           `росси\p{L}*|russia`,
+		"Belarus":          `беларус\p{L}*|белорус\p{L}*|belarus`,
+		"Ukraine":          `украин\p{L}*|ukraine`,
+		"Kazakhstan":       `казахстан\p{L}*|kazakhstan`,
+		"Cyprus":           `кипр\p{L}*|cyprus`,
+		"Germany":          `германи\p{L}*|germany`,
+		"Netherlands":      `нидерланд\p{L}*|голланди\p{L}*|netherlands`,
+		"Poland":           `польш\p{L}*|poland`,
+		"Serbia":           `серби\p{L}*|serbia`,
+		"USA":              `сша|usa`,
+	})
+
+	seniorityTerms = []term{
+		{"junior", regexp.MustCompile(wordStart + `(?:junior|джун\p{L}*|младш\p{L}*)` + wordEnd)},
+		{"middle", regexp.MustCompile(wordStart + `(?:middle|мидл\p{L}*)` + wordEnd)},
+		{"senior", regexp.MustCompile(wordStart + `(?:senior|сеньор\p{L}*|синьор\p{L}*|старш\p{L}*)` + wordEnd)},
+		{"lead", regexp.MustCompile(wordStart + `(?:lead|лид|тимлид\p{L}*|ведущ\p{L}*)` + wordEnd)},
+	}
+
+	employmentTerms = []term{
+		{"full-time", regexp.MustCompile(`full[- ]time|полн\p{L}* (?:занятост|рабоч\p{L}* день|день)|фулл?[- ]тайм`)},
+		{"part-time", regexp.MustCompile(`part[- ]time|частичн\p{L}* занятост|неполн\p{L}* (?:занятост|рабоч|день)|парт[- ]тайм`)},
+		{"contract", regexp.MustCompile(`contract|контракт|freelance|фриланс|проектн\p{L}* работ`)},
+		{"internship", regexp.MustCompile(wordStart + `(?:intern|internship|стаж[её]р\p{L}*|стажировк\p{L}*)` + wordEnd)},
+	}
+
+	stackTerms = terms(map[string]string{
+		"Selenium":     `selenium`,
+		"Selenide":     `selenide`,
+		"Appium":       `appium`,
+		"Cypress":      `cypress`,
+		"Playwright":   `playwright`,
+		"WebdriverIO":  `webdriver\.?io|wdio`,
+		"Java":         `java`,
+		"C#":           `[cс]#|\.net`,
+		"Python":       `python|питон`,
+		"JavaScript":   `javascript|js`,
+		"TypeScript":   `typescript`,
+		"Kotlin":       `kotlin`,
+		"Swift":        `swift`,
+		"Go":           `golang`,
+		"Ruby":         `ruby`,
+		"PHP":          `php`,
+		"SQL":          `sql|postgres\p{L}*|mysql`,
+		"JMeter":       `jmeter`,
+		"Gatling":      `gatling`,
+		"Postman":      `postman`,
+		"SoapUI":       `soap ?ui`,
+		"REST Assured": `rest[- ]?assured`,
+		"TestNG":       `testng`,
+		"JUnit":        `junit`,
+		"pytest":       `pytest`,
+		"Cucumber":     `cucumber`,
+		"Allure":       `allure`,
+		"Jenkins":      `jenkins`,
+		"Docker":       `docker`,
+		"Kubernetes":   `kubernetes|k8s`,
+		"Git":          `git`,
+		"Jira":         `jira`,
+		"TestRail":     `testrail`,
+	})
+)
+
+// terms compiles name -> pattern pairs into whole word matchers sorted by
+// name, so extracted lists come out in a stable order.
+func terms(patterns map[string]string) []term {
+	var result []term
+	for name, p := range patterns {
+		result = append(result, term{name, regexp.MustCompile(wordStart + `(?:` + p + `)` + wordEnd)})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].name < result[j].name
+	})
+	return result
+}
+
+func matchTerms(text string, list []term) []string {
+	var result []string
+	for _, t := range list {
+		if t.re.MatchString(text) {
+			result = append(result, t.name)
+		}
+	}
+	return result
+}
+
+func extractFields(text string) fields {
+	text = strings.ToLower(text)
+	f := fields{
+		Locations:  matchTerms(text, locationTerms),
+		Remote:     containsKeyword(text, []string{"удаленн", "удалённ", "удаленк", "удалёнк", "remote", "work from home", "wfh"}),
+		Relocation: containsKeyword(text, []string{"релокац", "relocation", "relocate", "переезд"}),
+		Seniority:  matchTerms(text, seniorityTerms),
+		Employment: matchTerms(text, employmentTerms),
+		Stack:      matchTerms(text, stackTerms),
+	}
+	f.SalaryMin, f.SalaryMax, f.Currency = extractSalary(text)
+	f.Company = extractCompany(text)
+	return f
+}
+
+// Domains of job boards, mail services and link shorteners say nothing
+// about the employer.
+var commonDomains = []string{
+	"hh.ru", "headhunter.ru", "hh.kz", "rabota.by", "linkedin.com", "lnkd.in", "djinni.co", "habr.com", "moikrug.ru",
+	"superjob.ru", "work.ua", "rabota.ua", "glassdoor.com", "indeed.com", "angel.co",
+	"gmail.com", "googlemail.com", "yandex.ru", "ya.ru", "mail.ru", "bk.ru", "inbox.ru", "list.ru", "rambler.ru",
+	"outlook.com", "hotmail.com", "yahoo.com", "icloud.com", "protonmail.com",
+	"t.me", "telegram.me", "slack.com", "skype.com", "google.com", "goo.gl", "forms.gle", "bit.ly", "github.com",
+}
+
+// extractCompany guesses the employer by the first domain in links and
+// emails that doesn't belong to a common service.
+func extractCompany(text string) string {
+	for _, m := range domainRe.FindAllStringSubmatch(text, -1) {
+		labels := strings.Split(m[1], ".")
+		n := 2
+		if len(labels) > 2 && (labels[len(labels)-2] == "co" || labels[len(labels)-2] == "com") {
+			n = 3
+		}
+		if len(labels) > n {
+			labels = labels[len(labels)-n:]
+		}
+		domain := strings.Join(labels, ".")
+		if !contains(commonDomains, domain) {
+			return domain
+		}
+	}
+	return ""
+}
+
+// extractSalary returns the first amount that has a currency or a thousands
+// multiplier; plain numbers are too often years of experience or team size.
+func extractSalary(text string) (int, int, string) {
+	for _, m := range salaryRe.FindAllStringSubmatchIndex(text, -1) {
+		group := func(i int) string {
+			if m[2*i] < 0 {
+				return ""
+			}
+			return text[m[2*i]:m[2*i+1]]
+		}
+		mult1, mult2 := group(4), group(6)
+		if mult1 != "" && followedByLetter(text, m[9]) {
+			continue
+		}
+		if mult2 != "" && followedByLetter(text, m[13]) {
+			mult2 = ""
+		}
+		cur := currencies[group(2)]
+		if c, ok := currencies[group(7)]; ok && !followedByLetter(text, m[15]) {
+			cur = c
+		}
+		if cur == "" && mult1 == "" && mult2 == "" {
+			continue
+		}
+		if cur == "" && (strings.HasPrefix(mult1, "к") || strings.HasPrefix(mult1, "т") ||
+			strings.HasPrefix(mult2, "к") || strings.HasPrefix(mult2, "т")) {
+			cur = "RUB"
+		}
+
+		low := amount(group(3), mult1)
+		if group(5) != "" {
+			// "150-200k" shares the multiplier of the upper bound.
+			if mult1 == "" {
+				low = amount(group(3), mult2)
+			}
+			return low, amount(group(5), mult2), cur
+		}
+		switch group(1) {
+		case "от", "from":
+			return low, 0, cur
+		case "до", "up to":
+			return 0, low, cur
+		}
+		return low, low, cur
+	}
+	return 0, 0, ""
+}
+
+func amount(number, mult string) int {
+	if thousandsRe.MatchString(number) {
+		number = strings.NewReplacer(" ", "", "\u00a0", "", ",", "").Replace(number)
+	} else {
+		number = strings.Replace(number, ",", ".", 1)
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0
+	}
+	if mult != "" {
+		value *= 1000
+	}
+	return int(value)
+}
+
+func followedByLetter(text string, end int) bool {
+	if end < 0 || end >= len(text) {
+		return false
+	}
+	for _, r := range text[end:] {
+		return unicode.IsLetter(r)
+	}
+	return false
+}
+
+// SalaryRange formats the salary for templates, e.g. "150000-200000 RUB".
+func (f fields) SalaryRange() string {
+	var s string
+	switch {
+	case f.SalaryMin == 0 && f.SalaryMax == 0:
+		return ""
+	case f.SalaryMin == f.SalaryMax:
+		s = strconv.Itoa(f.SalaryMin)
+	case f.SalaryMax == 0:
+		s = "from " + strconv.Itoa(f.SalaryMin)
+	case f.SalaryMin == 0:
+		s = "up to " + strconv.Itoa(f.SalaryMax)
+	default:
+		s = strconv.Itoa(f.SalaryMin) + "-" + strconv.Itoa(f.SalaryMax)
+	}
+	if f.Currency != "" {
+		s += " " + f.Currency
+	}
+	return s
+}
@@ -0,0 +1,94 @@
+package bot
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractSalary(t *testing.T) {
+	cases := []struct {
+		in       string
+		min, max int
+		currency string
+	}{
+		{"Зарплата от 150 000 до 200 000 руб", 150000, 200000, "RUB"},
+		{"ЗП 150-200к на руки", 150000, 200000, "RUB"},
+		{"вилка 120-150 тыс. рублей", 120000, 150000, "RUB"},
+		{"Salary $3000-4000 gross", 3000, 4000, "USD"},
+		{"salary up to 5k €", 0, 5000, "EUR"},
+		{"от 180к", 180000, 0, "RUB"},
+		{"оклад 250 000 ₽", 250000, 250000, "RUB"},
+		{"$150,000 per year", 150000, 150000, "USD"},
+		{"2,5k usd", 2500, 2500, "USD"},
+		{"Опыт от 3 лет, команда 5 компаний", 0, 0, ""},
+		{"http://hh.ru/vacancy/12345678", 0, 0, ""},
+	}
+
+	for _, v := range cases {
+		f := extractFields(v.in)
+		if f.SalaryMin != v.min || f.SalaryMax != v.max || f.Currency != v.currency {
+			t.Errorf("For string: %s, actual result: %d-%d %s, expected: %d-%d %s",
+				v.in, f.SalaryMin, f.SalaryMax, f.Currency, v.min, v.max, v.currency)
+		}
+	}
+}
+
+func TestExtractFields(t *testing.T) {
+	text := "Ищем Senior QA Automation (Java, Selenium, REST Assured) в Москву или удалённо, " +
+		"помогаем с релокацией на Кипр. Полная занятость. Автоматизация на С#, знание SQL и Git приветствуется. " +
+		"Подробнее на https://github.com/company/jobs"
+
+	expected := fields{
+		Locations:  []string{"Cyprus", "Moscow"},
+		Remote:     true,
+		Relocation: true,
+		Seniority:  []string{"senior"},
+		Employment: []string{"full-time"},
+		Stack:      []string{"C#", "Git", "Java", "REST Assured", "SQL", "Selenium"},
+	}
+	result := extractFields(text)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Actual result: %+v, expected: %+v", result, expected)
+	}
+}
+
+func TestSalaryRange(t *testing.T) {
+	cases := []struct {
+		in  fields
+		out string
+	}{
+		{fields{}, ""},
+		{fields{SalaryMin: 100, SalaryMax: 100, Currency: "USD"}, "100 USD"},
+		{fields{SalaryMin: 100, Currency: "RUB"}, "from 100 RUB"},
+		{fields{SalaryMax: 100}, "up to 100"},
+		{fields{SalaryMin: 100, SalaryMax: 200, Currency: "EUR"}, "100-200 EUR"},
+	}
+
+	for _, v := range cases {
+		result := v.in.SalaryRange()
+		if result != v.out {
+			t.Errorf("For fields: %+v, actual result: %s, expected: %s", v.in, result, v.out)
+		}
+	}
+}
+
+func TestExtractCompany(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{"Вакансия https://careers.epam.com/job/1", "epam.com"},
+		{"<http://www.kaspersky.ru/jobs|Kaspersky>", "kaspersky.ru"},
+		{"CV на vasya@gmail.com или hr@tinkoff.ru", "tinkoff.ru"},
+		{"https://hh.ru/vacancy/1 компания https://company.co.uk/about", "company.co.uk"},
+		{"пишите @vasya.petrov в личку", ""},
+		{"https://hh.ru/vacancy/1", ""},
+	}
+
+	for _, v := range cases {
+		result := extractCompany(v.in)
+		if result != v.out {
+			t.Errorf("For string: %s, actual result: %s, expected: %s", v.in, result, v.out)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultExternalFormat = "{{.Text}}\n(shared from #{{.Channel}} of {{.Workspace}})"
+	webhookMethod         = "webhook"
+)
+
+// Destination is a channel outside of the workspace a route reposts to as
+// well: a channel of another workspace, posted to with a token of that
+// workspace, or an incoming webhook. Categories and Filter, in the syntax
+// of subscriptions, limit the postings it gets.
+type Destination struct {
+	Name       string   `json:"name"`
+	Webhook    string   `json:"webhook"`
+	Token      string   `json:"token"`
+	TokenFile  string   `json:"token_file"`
+	Channel    string   `json:"channel"`
+	Categories []string `json:"categories"`
+	Filter     string   `json:"filter"`
+	Format     string   `json:"format"`
+
+	filter    *subscription
+	template  *template.Template
+	client    Slacker
+	channelID string
+}
+
+func (d *Destination) compile() error {
+	if d.Name == "" {
+		return errors.New("External destination needs a name")
+	}
+	var err error
+	if d.Token, err = ReadSecret(d.Token, d.TokenFile); err != nil {
+		return errors.New("Can't read token of destination " + d.Name + ": " + err.Error())
+	}
+	switch {
+	case d.Webhook != "" && d.Token != "":
+		return errors.New("Destination " + d.Name + " needs either webhook or token, not both")
+	case d.Webhook == "" && d.Token == "":
+		return errors.New("Destination " + d.Name + " needs webhook or token")
+	case d.Token != "" && d.Channel == "":
+		return errors.New("Destination " + d.Name + " needs a channel to post to with the token")
+	}
+	d.filter = nil
+	if d.Filter != "" {
+		s, err := parseSubscription("", strings.Fields(d.Filter))
+		if err != nil {
+			return errors.New("Wrong filter of destination " + d.Name + ": " + err.Error())
+		}
+		d.filter = &s
+	}
+	if d.Format == "" {
+		d.Format = defaultExternalFormat
+	}
+	tmpl, err := template.New(d.Name).Parse(d.Format)
+	if err != nil {
+		return err
+	}
+	d.template = tmpl
+	return nil
+}
+
+// resolve finds the channel of a token destination in its workspace.
+func (d *Destination) resolve() error {
+	if d.client == nil {
+		return nil
+	}
+	channels, err := d.client.Channels()
+	if err != nil {
+		return errors.New("Can't get list of channels: " + err.Error())
+	}
+	ids, err := channels.ids([]string{d.Channel})
+	if err != nil {
+		return err
+	}
+	d.channelID = ids[0]
+	return nil
+}
+
+func (d *Destination) accepts(p posting) bool {
+	return hasCategory(d.Categories, p.Tags) && (d.filter == nil || d.filter.matches(p))
+}
+
+func (d *Destination) format(msg repost) (string, error) {
+	var buf bytes.Buffer
+	err := d.template.Execute(&buf, msg)
+	return buf.String(), err
+}
+
+// externalKey identifies the destination in outbox jobs.
+func externalKey(rt *Route, d *Destination) string {
+	return rt.Name + "/" + d.Name
+}
+
+// destination looks up the destination of an outbox job. It is gone if the
+// config changed while the job waited.
+func (b *Bot) destination(key string) *Destination {
+	for _, rt := range b.cfg.Routes {
+		for _, d := range rt.External {
+			if externalKey(rt, d) == key {
+				return d
+			}
+		}
+	}
+	return nil
+}
+
+func (b *Bot) publish(j job) error {
+	d := b.destination(j.Destination)
+	if d == nil {
+		return &apiError{Method: j.Destination, Code: "unknown_destination"}
+	}
+	if d.Webhook != "" {
+		return postWebhook(b.cfg.Workspace, d.Webhook, j.Text)
+	}
+	_, err := d.client.Repost(d.channelID, j.Text)
+	return err
+}
+
+// postWebhook posts the text to a Slack incoming webhook. Slack replies
+// to a bad payload or a removed webhook with 4xx and a code in the body,
+// retrying those won't help.
+func postWebhook(workspace, url, text string) error {
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	return observeAPI(workspace, webhookMethod, func() error {
+		resp, err := httpClient.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			return nil
+		case resp.StatusCode == http.StatusTooManyRequests:
+			seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+			return &rateLimitError{Method: webhookMethod, RetryAfter: time.Duration(seconds) * time.Second}
+		case resp.StatusCode >= 400 && resp.StatusCode < 500:
+			reply, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+			code := strings.TrimSpace(string(reply))
+			if code == "" {
+				code = resp.Status
+			}
+			return &apiError{Method: webhookMethod, Code: code}
+		}
+		return errors.New(webhookMethod + ": " + resp.Status)
+	})
+}
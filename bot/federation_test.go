@@ -0,0 +1,171 @@
+package bot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+func TestDestinationCompile(t *testing.T) {
+	cases := []struct {
+		d   Destination
+		err string
+	}{
+		{Destination{Name: "hook", Webhook: "https://hooks.slack.com/x", Filter: "remote tag:automation"}, ""},
+		{Destination{Name: "partners", Token: "xoxb", Channel: "jobs"}, ""},
+		{Destination{Webhook: "https://hooks.slack.com/x"}, "External destination needs a name"},
+		{Destination{Name: "none"}, "Destination none needs webhook or token"},
+		{Destination{Name: "both", Webhook: "https://hooks.slack.com/x", Token: "xoxb", Channel: "jobs"}, "Destination both needs either webhook or token, not both"},
+		{Destination{Name: "nochannel", Token: "xoxb"}, "Destination nochannel needs a channel to post to with the token"},
+		{Destination{Name: "format", Webhook: "https://hooks.slack.com/x", Format: "{{.Text"}, "template: format:1: unclosed action"},
+	}
+
+	for _, v := range cases {
+		err := v.d.compile()
+		if v.err == "" && err != nil || v.err != "" && (err == nil || err.Error() != v.err) {
+			t.Errorf("For destination %s, actual error: %v, expected: %s", v.d.Name, err, v.err)
+		}
+	}
+}
+
+// webhookServer replies to posts with the statuses in order, then with 200.
+type webhookServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	texts    []string
+}
+
+func newWebhookServer(statuses ...int) *webhookServer {
+	s := &webhookServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Text string `json:"text"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if len(s.statuses) > 0 {
+			status := s.statuses[0]
+			s.statuses = s.statuses[1:]
+			if status != http.StatusOK {
+				http.Error(w, "no_service", status)
+				return
+			}
+		}
+		s.texts = append(s.texts, payload.Text)
+	}))
+	return s
+}
+
+func (s *webhookServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.texts...)
+}
+
+func TestExternalDestinations(t *testing.T) {
+	flaky := newWebhookServer(http.StatusServiceUnavailable)
+	defer flaky.Close()
+	removed := newWebhookServer(http.StatusNotFound)
+	defer removed.Close()
+	filtered := newWebhookServer()
+	defer filtered.Close()
+	partner := newFakeSlack()
+	partner.addChannel("C9", "qa-jobs")
+
+	db := openTestDB(t)
+	defer closeTestDB(db)
+	now := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	client := newFakeSlack()
+	client.addChannel("111", "general")
+	client.addChannel("333", "jobs")
+	rt := &Route{Name: "jobs", From: []string{"general"}, To: []string{"jobs"}, External: []*Destination{
+		{Name: "flaky", Webhook: flaky.URL},
+		{Name: "removed", Webhook: removed.URL},
+		{Name: "mobile", Webhook: filtered.URL, Categories: []string{"mobile"}},
+		{Name: "partner", Token: "xoxb-partner", Channel: "#qa-jobs", Format: "{{.Text}} via {{.Workspace}}"},
+	}}
+	b, err := NewBot(Config{BotName: "bot", Routes: []*Route{rt}, Categories: DefaultCategories()}, Deps{
+		Slack: client,
+		Store: db,
+		Now:   func() time.Time { return now },
+		Connect: func(token string) Slacker {
+			if token != "xoxb-partner" {
+				t.Errorf("Actual token: %s", token)
+			}
+			return partner
+		},
+	})
+	if err != nil {
+		t.Fatal("Can't create bot: ", err)
+	}
+	if err := b.Init(); err != nil {
+		t.Fatal("Can't init bot: ", err)
+	}
+
+	ev := &slack.MessageEvent{Msg: slack.Msg{Channel: "111", Text: "Selenium vacancy http://hh.ru/vacancy/1"}}
+	if err := b.RepostMessage(ev); err == nil {
+		t.Error("Failed destinations should be reported")
+	}
+	if posts := client.posts()["333"]; len(posts) != 1 {
+		t.Errorf("Actual posts in target channel: %v", posts)
+	}
+	if posts := partner.posts()["C9"]; len(posts) != 1 || posts[0] != ev.Text+" via https://qa.slack.com/" {
+		t.Errorf("Actual posts in partner channel: %v", posts)
+	}
+	if len(filtered.received()) != 0 {
+		t.Errorf("Filtered destination got: %v", filtered.received())
+	}
+
+	jobs, err := listJobs(db)
+	if err != nil {
+		t.Fatal("Can't list jobs: ", err)
+	}
+	if len(jobs) != 1 || jobs[0].Destination != "jobs/flaky" || jobs[0].Attempts != 1 {
+		t.Fatalf("Only the failed destination should wait for a retry, actual jobs: %+v", jobs)
+	}
+
+	now = now.Add(time.Hour)
+	jobs, _ = claimJobs(now, db)
+	if err := b.deliver(jobs); err != nil {
+		t.Error("Can't retry: ", err)
+	}
+	want := ev.Text + "\n(shared from #general of https://qa.slack.com/)"
+	if texts := flaky.received(); len(texts) != 1 || texts[0] != want {
+		t.Errorf("Actual texts of retried webhook: %q", texts)
+	}
+	if jobs, _ := listJobs(db); len(jobs) != 0 {
+		t.Errorf("Outbox isn't empty: %+v", jobs)
+	}
+	if len(removed.received()) != 0 || metricDropped.Value(jobExternal) == 0 {
+		t.Error("Removed webhook should be dropped")
+	}
+}
+
+func TestPostWebhook(t *testing.T) {
+	cases := []struct {
+		status int
+		err    string
+	}{
+		{http.StatusOK, ""},
+		{http.StatusNotFound, "webhook: no_service"},
+		{http.StatusTooManyRequests, "webhook: rate limited, retry after 0s"},
+		{http.StatusBadGateway, "webhook: 502 Bad Gateway"},
+	}
+
+	for _, v := range cases {
+		s := newWebhookServer(v.status)
+		err := postWebhook("", s.URL, "text")
+		s.Close()
+		if v.err == "" && err != nil || v.err != "" && (err == nil || !strings.HasPrefix(err.Error(), v.err)) {
+			t.Errorf("For status %d, actual error: %v, expected: %s", v.status, err, v.err)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package bot
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultFeedTitle = "QA vacancies"
+	defaultFeedLimit = 50
+	feedTitleLength  = 100
+	jsonFeedVersion  = "https://jsonfeed.org/version/1.1"
+)
+
+// Feed serves the latest reposts as RSS 2.0, Atom and JSON Feed for people
+// outside of Slack. Link is the home page of the feed, the workspace by
+// default.
+type Feed struct {
+	Title string `json:"title"`
+	Link  string `json:"link"`
+	Limit int    `json:"limit"`
+}
+
+func (f *Feed) compile() error {
+	if f.Limit < 0 {
+		return errors.New("feed limit can't be negative")
+	}
+	if f.Limit == 0 {
+		f.Limit = defaultFeedLimit
+	}
+	if f.Title == "" {
+		f.Title = defaultFeedTitle
+	}
+	return nil
+}
+
+// feedItem is a posting prepared for any of the formats.
+type feedItem struct {
+	ID     string
+	Link   string
+	Title  string
+	Text   string
+	Author string
+	Tags   []string
+	Fields fields
+	Time   time.Time
+}
+
+func newFeedItem(p posting) feedItem {
+	text := plainText(p.Text)
+	title := strings.TrimSpace(strings.SplitN(text, "\n", 2)[0])
+	if runes := []rune(title); len(runes) > feedTitleLength {
+		title = string(runes[:feedTitleLength]) + "…"
+	}
+	// Postings reposted before the workspace URL was known link to the
+	// channel in Slack markup, such items have no link.
+	link := p.Link
+	if !strings.HasPrefix(link, "https://") && !strings.HasPrefix(link, "http://") {
+		link = ""
+	}
+	id := link
+	if id == "" {
+		id = "urn:qa-slack-bot:" + p.Channel + ":" + p.Timestamp
+	}
+	return feedItem{
+		ID:     id,
+		Link:   link,
+		Title:  title,
+		Text:   text,
+		Author: p.Author,
+		Tags:   p.Tags,
+		Fields: p.Fields,
+		Time:   p.Time,
+	}
+}
+
+// plainText turns Slack markup left in a repost into plain text: links
+// become their URLs, with the label if Slack didn't make it from the URL,
+// and escaped characters are restored.
+func plainText(text string) string {
+	var buf strings.Builder
+	for _, t := range tokenize(text) {
+		url := strings.TrimPrefix(t.Value, "mailto:")
+		switch {
+		case t.Kind == markupLink && t.Label != "" && !strings.HasSuffix(url, t.Label):
+			buf.WriteString(t.Label + " (" + url + ")")
+		case t.Kind == markupLink:
+			buf.WriteString(url)
+		case t.Label != "":
+			buf.WriteString(t.Label)
+		default:
+			buf.WriteString(t.Raw)
+		}
+	}
+	return strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&").Replace(buf.String())
+}
+
+// feedFilter reads filters from the query: category and tag (both may
+// repeat), remote, relocation, location and q with keywords. Every filter
+// has to match, the same as for subscriptions.
+func feedFilter(r *http.Request) (*subscription, error) {
+	query := r.URL.Query()
+	var args []string
+	for _, name := range []string{"category", "tag"} {
+		for _, v := range query[name] {
+			args = append(args, "tag:"+v)
+		}
+	}
+	for _, v := range query["location"] {
+		args = append(args, "location:"+v)
+	}
+	args = append(args, strings.Fields(query.Get("q"))...)
+	for _, name := range []string{"remote", "relocation"} {
+		if v := query.Get(name); v != "" {
+			on, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, errors.New("Wrong value of " + name + ": " + v)
+			}
+			if on {
+				args = append(args, name)
+			}
+		}
+	}
+	if len(args) == 0 {
+		return nil, nil
+	}
+	s, err := parseSubscription("", args)
+	return &s, err
+}
+
+// feedItems returns the latest postings matching the filters, newest first.
+func (b *Bot) feedItems(filter *subscription) ([]feedItem, error) {
+	postings, err := listPostings(time.Time{}, b.now().Add(time.Minute), b.store)
+	if err != nil {
+		return nil, err
+	}
+	var result []feedItem
+	for i := len(postings) - 1; i >= 0 && len(result) < b.cfg.Feed.Limit; i-- {
+		if filter == nil || filter.matches(postings[i]) {
+			result = append(result, newFeedItem(postings[i]))
+		}
+	}
+	return result, nil
+}
+
+func (b *Bot) feedLink() string {
+	if b.cfg.Feed.Link != "" {
+		return b.cfg.Feed.Link
+	}
+	return b.teamURL
+}
+
+// requestURL rebuilds the URL the feed was requested with, behind a proxy
+// as well.
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link,omitempty"`
+	Description string   `xml:"description"`
+	Categories  []string `xml:"category"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Link       *atomLink      `xml:"link,omitempty"`
+	Author     *atomAuthor    `xml:"author,omitempty"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomContent    `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url,omitempty"`
+	FeedURL     string         `json:"feed_url"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url,omitempty"`
+	Title         string           `json:"title"`
+	ContentText   string           `json:"content_text"`
+	DatePublished time.Time        `json:"date_published"`
+	Authors       []jsonFeedAuthor `json:"authors,omitempty"`
+	Tags          []string         `json:"tags,omitempty"`
+	Fields        fields           `json:"_fields"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+// feedHandler serves the feed in the format: rss, atom or json.
+func (b *Bot) feedHandler(format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := feedFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		items, err := b.feedItems(filter)
+		if err != nil {
+			http.Error(w, "Can't read postings: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		updated := b.now()
+		if len(items) > 0 {
+			updated = items[0].Time
+		}
+		switch format {
+		case "rss":
+			writeXML(w, "application/rss+xml; charset=utf-8", buildRSS(b.cfg.Feed.Title, b.feedLink(), updated, items))
+		case "atom":
+			writeXML(w, "application/atom+xml; charset=utf-8", buildAtom(b.cfg.Feed.Title, b.feedLink(), requestURL(r), updated, items))
+		default:
+			w.Header().Set("Content-Type", "application/feed+json; charset=utf-8")
+			json.NewEncoder(w).Encode(buildJSONFeed(b.cfg.Feed.Title, b.feedLink(), requestURL(r), items))
+		}
+	}
+}
+
+func writeXML(w http.ResponseWriter, contentType string, v interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(v)
+}
+
+func buildRSS(title, link string, updated time.Time, items []feedItem) rssFeed {
+	f := rssFeed{Version: "2.0", Channel: rssChannel{
+		Title:         title,
+		Link:          link,
+		Description:   title,
+		LastBuildDate: updated.Format(time.RFC1123Z),
+	}}
+	for _, it := range items {
+		f.Channel.Items = append(f.Channel.Items, rssItem{
+			Title:       it.Title,
+			Link:        it.Link,
+			Description: it.Text,
+			Categories:  it.Tags,
+			GUID:        rssGUID{IsPermaLink: it.ID == it.Link, Value: it.ID},
+			PubDate:     it.Time.Format(time.RFC1123Z),
+		})
+	}
+	return f
+}
+
+func buildAtom(title, link, self string, updated time.Time, items []feedItem) atomFeed {
+	f := atomFeed{
+		Title:   title,
+		ID:      self,
+		Updated: updated.Format(time.RFC3339),
+		Author:  atomAuthor{Name: title},
+		Links:   []atomLink{{Rel: "self", Href: self}},
+	}
+	if link != "" {
+		f.Links = append(f.Links, atomLink{Rel: "alternate", Href: link})
+	}
+	for _, it := range items {
+		e := atomEntry{
+			Title:   it.Title,
+			ID:      it.ID,
+			Updated: it.Time.Format(time.RFC3339),
+			Content: atomContent{Type: "text", Value: it.Text},
+		}
+		if it.Link != "" {
+			e.Link = &atomLink{Href: it.Link}
+		}
+		if it.Author != "" {
+			e.Author = &atomAuthor{Name: it.Author}
+		}
+		for _, tag := range it.Tags {
+			e.Categories = append(e.Categories, atomCategory{Term: tag})
+		}
+		f.Entries = append(f.Entries, e)
+	}
+	return f
+}
+
+func buildJSONFeed(title, link, self string, items []feedItem) jsonFeed {
+	f := jsonFeed{Version: jsonFeedVersion, Title: title, HomePageURL: link, FeedURL: self, Items: []jsonFeedItem{}}
+	for _, it := range items {
+		item := jsonFeedItem{
+			ID:            it.ID,
+			URL:           it.Link,
+			Title:         it.Title,
+			ContentText:   it.Text,
+			DatePublished: it.Time,
+			Tags:          it.Tags,
+			Fields:        it.Fields,
+		}
+		if it.Author != "" {
+			item.Authors = []jsonFeedAuthor{{Name: it.Author}}
+		}
+		f.Items = append(f.Items, item)
+	}
+	return f
+}
@@ -0,0 +1,168 @@
+package bot
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlainText(t *testing.T) {
+	cases := []struct {
+		text, expected string
+	}{
+		{"QA &lt;remote&gt; &amp; more", "QA <remote> & more"},
+		{"Apply <https://hh.ru/vacancy/1|hh.ru/vacancy/1>", "Apply https://hh.ru/vacancy/1"},
+		{"Apply <https://hh.ru/vacancy/1|here>", "Apply here (https://hh.ru/vacancy/1)"},
+		{"Mail <mailto:hr@example.com|hr@example.com>", "Mail hr@example.com"},
+		{"Write <mailto:hr@example.com|HR>", "Write HR (hr@example.com)"},
+		{"By @vasya in #jobs", "By @vasya in #jobs"},
+	}
+
+	for _, v := range cases {
+		if actual := plainText(v.text); actual != v.expected {
+			t.Errorf("For text %q, actual: %q, expected: %q", v.text, actual, v.expected)
+		}
+	}
+}
+
+func newFeedBot(t *testing.T, now time.Time) (*Bot, func()) {
+	db := openTestDB(t)
+	for _, p := range []posting{
+		{Text: "Manual QA, office", Channel: "C1", Timestamp: "1.1", Tags: []string{"manual"}, Time: now.Add(-3 * time.Hour)},
+		{Text: "Remote SDET &amp; Selenium\n<https://hh.ru/vacancy/2|Apply>", Author: "vasya", Tags: []string{"automation"}, Link: "https://qa.slack.com/archives/C1/p2",
+			Fields: fields{Remote: true, Stack: []string{"Selenium"}}, Time: now.Add(-2 * time.Hour)},
+		{Text: "Remote mobile QA", Channel: "C1", Timestamp: "1.3", Tags: []string{"mobile"}, Fields: fields{Remote: true}, Time: now.Add(-time.Hour)},
+	} {
+		if err := savePosted(p.Text, p, db); err != nil {
+			t.Fatal("Can't save posting: ", err)
+		}
+	}
+	b := newTestBot(t, newFakeSlack(), db, Config{Feed: &Feed{Title: "QA jobs", Limit: 2}})
+	b.now = func() time.Time { return now }
+	b.teamURL = "https://qa.slack.com/"
+	return b, func() { closeTestDB(db) }
+}
+
+func getFeed(t *testing.T, b *Bot, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	b.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://bot.example.com"+path, nil))
+	return w
+}
+
+func TestJSONFeed(t *testing.T) {
+	now := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	b, cleanup := newFeedBot(t, now)
+	defer cleanup()
+
+	cases := []struct {
+		query string
+		ids   []string
+	}{
+		{"", []string{"urn:qa-slack-bot:C1:1.3", "https://qa.slack.com/archives/C1/p2"}},
+		{"?remote=true&category=automation", []string{"https://qa.slack.com/archives/C1/p2"}},
+		{"?tag=manual", []string{"urn:qa-slack-bot:C1:1.1"}},
+		{"?q=selenium", []string{"https://qa.slack.com/archives/C1/p2"}},
+		{"?remote=false&tag=security", nil},
+	}
+	for _, v := range cases {
+		w := getFeed(t, b, "/feed.json"+v.query)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/feed+json; charset=utf-8" {
+			t.Fatalf("For query %s, actual status: %d, content type: %s", v.query, w.Code, w.Header().Get("Content-Type"))
+		}
+		var f jsonFeed
+		if err := json.Unmarshal(w.Body.Bytes(), &f); err != nil {
+			t.Fatal("Can't parse feed: ", err)
+		}
+		var ids []string
+		for _, it := range f.Items {
+			ids = append(ids, it.ID)
+		}
+		if strings.Join(ids, " ") != strings.Join(v.ids, " ") {
+			t.Errorf("For query %s, actual items: %v, expected: %v", v.query, ids, v.ids)
+		}
+		if f.Version != jsonFeedVersion || f.Title != "QA jobs" || f.FeedURL != "http://bot.example.com/feed.json"+v.query {
+			t.Errorf("Wrong feed: %+v", f)
+		}
+	}
+
+	w := getFeed(t, b, "/feed.json?category=automation")
+	var f jsonFeed
+	json.Unmarshal(w.Body.Bytes(), &f)
+	it := f.Items[0]
+	if it.Title != "Remote SDET & Selenium" || it.ContentText != "Remote SDET & Selenium\nApply (https://hh.ru/vacancy/2)" ||
+		it.URL != "https://qa.slack.com/archives/C1/p2" || len(it.Authors) != 1 || it.Authors[0].Name != "vasya" || !it.Fields.Remote {
+		t.Errorf("Wrong item: %+v", it)
+	}
+
+	if w := getFeed(t, b, "/feed.json?remote=maybe"); w.Code != http.StatusBadRequest {
+		t.Errorf("Actual status for wrong filter: %d", w.Code)
+	}
+}
+
+func TestXMLFeeds(t *testing.T) {
+	now := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	b, cleanup := newFeedBot(t, now)
+	defer cleanup()
+
+	w := getFeed(t, b, "/feed.rss?category=automation")
+	var rss rssFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &rss); err != nil {
+		t.Fatal("Can't parse RSS: ", err)
+	}
+	if w.Header().Get("Content-Type") != "application/rss+xml; charset=utf-8" || rss.Version != "2.0" || rss.Channel.Link != "https://qa.slack.com/" || len(rss.Channel.Items) != 1 {
+		t.Fatalf("Wrong RSS: %s", w.Body.String())
+	}
+	item := rss.Channel.Items[0]
+	if item.Title != "Remote SDET & Selenium" || !item.GUID.IsPermaLink || item.PubDate != "Thu, 01 Mar 2018 08:00:00 +0000" || item.Categories[0] != "automation" {
+		t.Errorf("Wrong RSS item: %+v", item)
+	}
+
+	w = getFeed(t, b, "/feed.atom")
+	var atom atomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &atom); err != nil {
+		t.Fatal("Can't parse Atom: ", err)
+	}
+	if atom.ID != "http://bot.example.com/feed.atom" || atom.Updated != "2018-03-01T09:00:00Z" || len(atom.Entries) != 2 {
+		t.Fatalf("Wrong Atom: %s", w.Body.String())
+	}
+	if e := atom.Entries[0]; e.ID != "urn:qa-slack-bot:C1:1.3" || e.Link != nil || e.Content.Value != "Remote mobile QA" {
+		t.Errorf("Wrong Atom entry: %+v", e)
+	}
+}
+
+func TestFeedWithoutTeamURL(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+	b := newTestBot(t, newFakeSlack(), db, Config{Feed: &Feed{Title: "QA jobs"}})
+	p := posting{Text: "Manual QA", Channel: "C2", Timestamp: "1.1", Time: time.Date(2018, 3, 1, 9, 0, 0, 0, time.UTC)}
+	p.Link = b.permalink(p.Channel, p.Timestamp)
+	if err := savePosted(p.Text, p, db); err != nil {
+		t.Fatal("Can't save posting: ", err)
+	}
+
+	var rss rssFeed
+	if err := xml.Unmarshal(getFeed(t, b, "/feed.rss").Body.Bytes(), &rss); err != nil || len(rss.Channel.Items) != 1 {
+		t.Fatalf("Can't parse RSS: %v", err)
+	}
+	if item := rss.Channel.Items[0]; item.Link != "" || item.GUID.IsPermaLink || item.GUID.Value != "urn:qa-slack-bot:C2:1.1" {
+		t.Errorf("Wrong RSS item: %+v", item)
+	}
+	var f jsonFeed
+	json.Unmarshal(getFeed(t, b, "/feed.json").Body.Bytes(), &f)
+	if len(f.Items) != 1 || f.Items[0].URL != "" || f.Items[0].ID != "urn:qa-slack-bot:C2:1.1" {
+		t.Errorf("Wrong JSON feed items: %+v", f.Items)
+	}
+}
+
+func TestFeedIsOptional(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+	b := newTestBot(t, newFakeSlack(), db, Config{})
+	if w := getFeed(t, b, "/feed.rss"); w.Code != http.StatusNotFound {
+		t.Errorf("Feed isn't configured, actual status: %d", w.Code)
+	}
+}
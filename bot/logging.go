@@ -0,0 +1,94 @@
+package bot
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+const (
+	decisionReposted = "reposted"
+	decisionQueued   = "queued"
+	decisionDeleted  = "deleted"
+	decisionCommand  = "command"
+	decisionSkipped  = "skipped"
+	decisionFailed   = "failed"
+
+	skipOwnMessage = "own_message"
+)
+
+// ProcessMessage runs a message through reposting, deletion and commands
+// and logs the outcome as a single record.
+func (b *Bot) ProcessMessage(ev *slack.MessageEvent) {
+	start := time.Now()
+	repostErr := b.RepostMessage(ev)
+	countSkip(b.cfg.Workspace, repostErr)
+	deleteErr := b.DeleteMessage(ev)
+	commandErr := b.HandleCommand(ev)
+
+	decision, reason, err := decide(repostErr, deleteErr, commandErr)
+	level := slog.LevelInfo
+	switch {
+	case err != nil:
+		level = slog.LevelError
+		reason = err.Error()
+	case decision == decisionQueued:
+		level = slog.LevelWarn
+	case reason == skipWrongChannel:
+		// Messages from channels the bot doesn't watch are the bulk of traffic.
+		level = slog.LevelDebug
+	}
+	b.log.Log(context.Background(), level, "Message processed",
+		"channel", ev.Channel,
+		"user", ev.User,
+		"ts", ev.Timestamp,
+		"decision", decision,
+		"reason", reason,
+		"latency_ms", float64(time.Since(start).Microseconds())/1000,
+	)
+}
+
+// decide combines results of the handlers into a decision and the reason
+// behind it. Any unexpected error marks the message as failed, a delivery
+// waiting in the outbox as queued.
+func decide(repostErr, deleteErr, commandErr error) (string, string, error) {
+	for _, err := range []error{repostErr, deleteErr, commandErr} {
+		if err != nil && !expectedError(err) {
+			return decisionFailed, "", err
+		}
+	}
+	queued, _ := repostErr.(*queuedError)
+	if queued == nil {
+		queued, _ = deleteErr.(*queuedError)
+	}
+	switch {
+	case repostErr == nil:
+		return decisionReposted, "", nil
+	case deleteErr == nil:
+		return decisionDeleted, "", nil
+	case queued != nil:
+		return decisionQueued, queued.cause.Error(), nil
+	case commandErr == nil:
+		return decisionCommand, "", nil
+	}
+	reason := skipReason(repostErr)
+	if reason == skipWrongChannel && (deleteErr.Error() == wrongUserID || commandErr.Error() == wrongUserID) {
+		reason = skipOwnMessage
+	}
+	return decisionSkipped, reason, nil
+}
+
+// expectedError reports whether the error only means a handler didn't
+// apply to the message.
+func expectedError(err error) bool {
+	if _, ok := err.(*queuedError); ok {
+		return true
+	}
+	switch err.Error() {
+	case wrongChannelID, wrongUserID, notDirectMessage:
+		return true
+	}
+	return skipReason(err) != ""
+}
@@ -0,0 +1,67 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/nlopes/slack"
+)
+
+func TestDecide(t *testing.T) {
+	skip := func(s string) error { return errors.New(s) }
+	cases := []struct {
+		repost, delete, command error
+		decision, reason        string
+	}{
+		{nil, skip(wrongChannelID), skip(notDirectMessage), decisionReposted, ""},
+		{skip(messageIsNotJobPosting), skip(wrongChannelID), skip(notDirectMessage), decisionSkipped, skipNotJob},
+		{skip(wrongChannelID), nil, skip(notDirectMessage), decisionDeleted, ""},
+		{skip(wrongChannelID), skip(wrongUserID), skip(notDirectMessage), decisionSkipped, skipOwnMessage},
+		{skip(wrongChannelID), skip(wrongChannelID), nil, decisionCommand, ""},
+		{skip(wrongChannelID), skip(wrongChannelID), skip(notDirectMessage), decisionSkipped, skipWrongChannel},
+		{skip("channel_not_found"), skip(wrongChannelID), skip(notDirectMessage), decisionFailed, ""},
+		{&queuedError{cause: skip("chat.postMessage: ratelimited")}, skip(wrongChannelID), skip(notDirectMessage), decisionQueued, "chat.postMessage: ratelimited"},
+		{skip(wrongChannelID), &queuedError{cause: skip("chat.delete: internal_error")}, skip(notDirectMessage), decisionQueued, "chat.delete: internal_error"},
+	}
+
+	for _, v := range cases {
+		decision, reason, _ := decide(v.repost, v.delete, v.command)
+		if decision != v.decision || reason != v.reason {
+			t.Errorf("For errors: %v, %v, %v, actual result: %s/%s, expected: %s/%s",
+				v.repost, v.delete, v.command, decision, reason, v.decision, v.reason)
+		}
+	}
+}
+
+func TestProcessMessageLogsDecision(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+
+	var buf bytes.Buffer
+	handler := slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+	b := newTestBot(t, newFakeSlack(), db, Config{Routes: []*Route{testRoute("C1", "C2")}})
+	b.log = slog.New(handler)
+	b.ProcessMessage(&slack.MessageEvent{Msg: slack.Msg{Channel: "C1", User: "U1", Text: "hello", Timestamp: "1.000001"}})
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Can't parse log record %q: %v", buf.String(), err)
+	}
+	for k, v := range map[string]string{
+		"channel":  "C1",
+		"user":     "U1",
+		"ts":       "1.000001",
+		"decision": decisionSkipped,
+		"reason":   skipNotJob,
+	} {
+		if record[k] != v {
+			t.Errorf("Actual %s: %v, expected: %s", k, record[k], v)
+		}
+	}
+	if _, ok := record["latency_ms"]; !ok {
+		t.Errorf("Log record has no latency")
+	}
+}
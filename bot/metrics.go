@@ -0,0 +1,302 @@
+package bot
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/nlopes/slack"
+)
+
+const (
+	healthBucket = "HEALTH"
+
+	// Skip reasons reported by RepostMessage, as metric label values.
+	skipWrongChannel = "wrong_channel"
+	skipNotJob       = "not_job_posting"
+	skipDuplicate    = "already_posted"
+	skipNoRoute      = "no_route_for_category"
+)
+
+// Counters are process-wide like the default Prometheus registry: bots
+// running in one process add up, so they are served once for all of them.
+var (
+	metricEvents     = newCounter("qa_bot_events_total", "RTM events received by type.", "type")
+	metricReposts    = newCounter("qa_bot_reposts_total", "Messages reposted to target channels by workspace.", "workspace")
+	metricSkips      = newCounter("qa_bot_skips_total", "Messages not reposted by workspace and reason.", "workspace", "reason")
+	metricExternal   = newCounter("qa_bot_external_posts_total", "Reposts posted to external destinations by route/destination.", "destination")
+	metricDeletions  = newCounter("qa_bot_deletions_total", "Messages deleted in target channels by workspace.", "workspace")
+	metricAPIErrors  = newCounter("qa_bot_slack_api_errors_total", "Failed Slack Web API calls by workspace and method.", "workspace", "method")
+	metricAPILatency = newHistogram("qa_bot_slack_api_duration_seconds", "Latency of Slack Web API calls by method.", "method",
+		[]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10})
+	metricDropped    = newCounter("qa_bot_outbox_dropped_total", "Outbox jobs dropped after a permanent error or too many attempts by kind.", "kind")
+	metricQueueFull  = newCounter("qa_bot_queue_full_total", "Messages that waited for room in a full worker queue.")
+	metricReconnects = newCounter("qa_bot_rtm_reconnects_total", "RTM connections made after the first one.")
+)
+
+// counter is a Prometheus counter, optionally split by labels. Labels with
+// empty values are left out, like the workspace of a single bot.
+type counter struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	labels []string
+	values map[string]float64
+}
+
+func newCounter(name, help string, labels ...string) *counter {
+	return &counter{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+func (c *counter) Inc(values ...string) {
+	c.mu.Lock()
+	c.values[strings.Join(values, "\x00")]++
+	c.mu.Unlock()
+}
+
+func (c *counter) Value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[strings.Join(values, "\x00")]
+}
+
+func (c *counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if len(c.values) == 0 && len(c.labels) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+		return
+	}
+	for _, key := range sortedKeys(c.values) {
+		var pairs []string
+		for i, v := range strings.Split(key, "\x00") {
+			if v != "" && i < len(c.labels) {
+				pairs = append(pairs, fmt.Sprintf("%s=%q", c.labels[i], v))
+			}
+		}
+		labels := ""
+		if len(pairs) > 0 {
+			labels = "{" + strings.Join(pairs, ",") + "}"
+		}
+		fmt.Fprintf(w, "%s%s %v\n", c.name, labels, c.values[key])
+	}
+}
+
+// histogram is a Prometheus histogram split by a single label.
+type histogram struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	label   string
+	buckets []float64
+	counts  map[string][]uint64
+	sums    map[string]float64
+}
+
+func newHistogram(name, help, label string, buckets []float64) *histogram {
+	return &histogram{
+		name:    name,
+		help:    help,
+		label:   label,
+		buckets: buckets,
+		counts:  make(map[string][]uint64),
+		sums:    make(map[string]float64),
+	}
+}
+
+func (h *histogram) Observe(label string, v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	counts, ok := h.counts[label]
+	if !ok {
+		counts = make([]uint64, len(h.buckets)+1)
+		h.counts[label] = counts
+	}
+	for i, le := range h.buckets {
+		if v <= le {
+			counts[i]++
+		}
+	}
+	counts[len(h.buckets)]++
+	h.sums[label] += v
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, l := range sortedKeys(h.sums) {
+		counts := h.counts[l]
+		for i, le := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket{%s=%q,le=\"%v\"} %d\n", h.name, h.label, l, le, counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s=%q,le=\"+Inf\"} %d\n", h.name, h.label, l, counts[len(h.buckets)])
+		fmt.Fprintf(w, "%s_sum{%s=%q} %v\n", h.name, h.label, l, h.sums[l])
+		fmt.Fprintf(w, "%s_count{%s=%q} %d\n", h.name, h.label, l, counts[len(h.buckets)])
+	}
+}
+
+func sortedKeys(m map[string]float64) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// observeAPI times a Slack Web API call and counts it if it failed.
+func observeAPI(workspace, method string, call func() error) error {
+	start := time.Now()
+	err := call()
+	metricAPILatency.Observe(method, time.Since(start).Seconds())
+	if err != nil {
+		metricAPIErrors.Inc(workspace, method)
+	}
+	return err
+}
+
+// skipReason maps errors RepostMessage returns for messages it decided not
+// to repost to metric labels, and other errors to "".
+func skipReason(err error) string {
+	switch err.Error() {
+	case wrongChannelID:
+		return skipWrongChannel
+	case messageIsNotJobPosting:
+		return skipNotJob
+	case messageIsAlreadyPosted:
+		return skipDuplicate
+	case noRouteForCategory:
+		return skipNoRoute
+	}
+	return ""
+}
+
+func countSkip(workspace string, err error) {
+	if err == nil {
+		return
+	}
+	if reason := skipReason(err); reason != "" {
+		metricSkips.Inc(workspace, reason)
+	}
+}
+
+// trackConnection keeps the RTM connection state for readiness checks.
+func (b *Bot) trackConnection(ev interface{}) {
+	switch ev := ev.(type) {
+	case *slack.ConnectedEvent:
+		atomic.StoreInt32(&b.connected, 1)
+		if ev.ConnectionCount > 1 {
+			metricReconnects.Inc()
+		}
+	case *slack.DisconnectedEvent, *slack.ConnectingEvent:
+		atomic.StoreInt32(&b.connected, 0)
+	}
+}
+
+func (b *Bot) metricsHandler() http.HandlerFunc {
+	return metricsHandler([]string{""}, []*Bot{b})
+}
+
+// metricsHandler serves the counters of the process once and the state of
+// every bot, labeled by its workspace if it has a name.
+func metricsHandler(names []string, bots []*Bot) http.HandlerFunc {
+	gauges := []struct {
+		name, help string
+		value      func(b *Bot) int64
+	}{
+		{"qa_bot_store_size_bytes", "Size of the DB file.", func(b *Bot) int64 {
+			var size int64
+			b.store.View(func(tx *bolt.Tx) error {
+				size = tx.Size()
+				return nil
+			})
+			return size
+		}},
+		{"qa_bot_outbox_jobs", "Jobs waiting in the outbox.", func(b *Bot) int64 {
+			var pending int
+			b.store.View(func(tx *bolt.Tx) error {
+				pending = tx.Bucket([]byte(outboxBucket)).Stats().KeyN
+				return nil
+			})
+			return int64(pending)
+		}},
+		{"qa_bot_queued_messages", "Messages waiting for or being processed by workers.", func(b *Bot) int64 {
+			return int64(b.messages.Queued())
+		}},
+		{"qa_bot_rtm_connected", "Whether the RTM connection is established.", func(b *Bot) int64 {
+			return int64(atomic.LoadInt32(&b.connected))
+		}},
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, c := range []*counter{metricEvents, metricReposts, metricExternal, metricSkips, metricDeletions, metricAPIErrors, metricDropped, metricQueueFull, metricReconnects} {
+			c.write(w)
+		}
+		metricAPILatency.write(w)
+
+		for _, g := range gauges {
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+			for i, b := range bots {
+				labels := ""
+				if names[i] != "" {
+					labels = fmt.Sprintf("{workspace=%q}", names[i])
+				}
+				fmt.Fprintf(w, "%s%s %d\n", g.name, labels, g.value(b))
+			}
+		}
+	}
+}
+
+// dbWritable checks the DB by committing a write transaction.
+func dbWritable(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(healthBucket))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte("checked"), []byte(time.Now().UTC().Format(time.RFC3339)))
+	})
+}
+
+// problems lists why the bot isn't alive, or isn't ready if ready is set:
+// the DB must be writable and, to be ready, RTM connected.
+func (b *Bot) problems(ready bool) []string {
+	var result []string
+	if ready && atomic.LoadInt32(&b.connected) == 0 {
+		result = append(result, "RTM is not connected")
+	}
+	if err := dbWritable(b.store); err != nil {
+		result = append(result, "DB is not writable: "+err.Error())
+	}
+	return result
+}
+
+func writeProblems(w http.ResponseWriter, problems []string) {
+	if len(problems) > 0 {
+		http.Error(w, strings.Join(problems, "\n"), http.StatusServiceUnavailable)
+		return
+	}
+	io.WriteString(w, "ok\n")
+}
+
+// healthHandler reports liveness: the DB is writable.
+func (b *Bot) healthHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeProblems(w, b.problems(false))
+	}
+}
+
+// readyHandler reports readiness: the DB is writable and RTM is connected.
+func (b *Bot) readyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeProblems(w, b.problems(true))
+	}
+}
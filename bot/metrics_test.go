@@ -0,0 +1,128 @@
+package bot
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nlopes/slack"
+)
+
+func TestCounterWrite(t *testing.T) {
+	c := newCounter("test_total", "Test counter.", "reason")
+	c.Inc("b")
+	c.Inc("a")
+	c.Inc("b")
+
+	var b strings.Builder
+	c.write(&b)
+	expected := "# HELP test_total Test counter.\n# TYPE test_total counter\n" +
+		"test_total{reason=\"a\"} 1\ntest_total{reason=\"b\"} 2\n"
+	if b.String() != expected {
+		t.Errorf("Actual result: %q, expected: %q", b.String(), expected)
+	}
+
+	c = newCounter("test_total", "Test counter.", "workspace", "reason")
+	c.Inc("", "a")
+	c.Inc("one", "a")
+	b.Reset()
+	c.write(&b)
+	expected = "# HELP test_total Test counter.\n# TYPE test_total counter\n" +
+		"test_total{reason=\"a\"} 1\ntest_total{workspace=\"one\",reason=\"a\"} 1\n"
+	if b.String() != expected {
+		t.Errorf("Actual result: %q, expected: %q", b.String(), expected)
+	}
+}
+
+func TestHistogramObserve(t *testing.T) {
+	h := newHistogram("test_seconds", "Test histogram.", "method", []float64{0.1, 1})
+	h.Observe("chat.delete", 0.05)
+	h.Observe("chat.delete", 0.5)
+	h.Observe("chat.delete", 3)
+
+	var b strings.Builder
+	h.write(&b)
+	for _, line := range []string{
+		"test_seconds_bucket{method=\"chat.delete\",le=\"0.1\"} 1\n",
+		"test_seconds_bucket{method=\"chat.delete\",le=\"1\"} 2\n",
+		"test_seconds_bucket{method=\"chat.delete\",le=\"+Inf\"} 3\n",
+		"test_seconds_sum{method=\"chat.delete\"} 3.55\n",
+		"test_seconds_count{method=\"chat.delete\"} 3\n",
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("Histogram output %q doesn't contain %q", b.String(), line)
+		}
+	}
+}
+
+func TestCountSkip(t *testing.T) {
+	before := metricSkips.Value("one", skipDuplicate)
+	countSkip("one", errors.New(messageIsAlreadyPosted))
+	countSkip("one", errors.New("channel_not_found"))
+	countSkip("one", nil)
+	if metricSkips.Value("one", skipDuplicate) != before+1 {
+		t.Errorf("Duplicate skip wasn't counted")
+	}
+	if metricSkips.Value("one", "") != 0 {
+		t.Errorf("Unknown error was counted as skip")
+	}
+}
+
+func TestObserveAPI(t *testing.T) {
+	before := metricAPIErrors.Value("", "test.method")
+	observeAPI("", "test.method", func() error { return nil })
+	observeAPI("", "test.method", func() error { return errors.New("ratelimited") })
+	if metricAPIErrors.Value("", "test.method") != before+1 {
+		t.Errorf("Failed call wasn't counted")
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+	b := newTestBot(t, newFakeSlack(), db, Config{})
+
+	cases := []struct {
+		handler http.HandlerFunc
+		event   interface{}
+		code    int
+	}{
+		{b.healthHandler(), &slack.ConnectingEvent{}, http.StatusOK},
+		{b.readyHandler(), &slack.ConnectingEvent{}, http.StatusServiceUnavailable},
+		{b.readyHandler(), &slack.ConnectedEvent{ConnectionCount: 1}, http.StatusOK},
+		{b.readyHandler(), &slack.DisconnectedEvent{}, http.StatusServiceUnavailable},
+	}
+
+	for _, v := range cases {
+		b.trackConnection(v.event)
+		w := httptest.NewRecorder()
+		v.handler(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != v.code {
+			t.Errorf("After event %T, actual code: %d, expected: %d", v.event, w.Code, v.code)
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+
+	b := newTestBot(t, newFakeSlack(), db, Config{})
+
+	metricEvents.Inc("message")
+	w := httptest.NewRecorder()
+	b.metricsHandler()(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, s := range []string{
+		"qa_bot_events_total{type=\"message\"}",
+		"# TYPE qa_bot_reposts_total counter",
+		"qa_bot_queue_full_total ",
+		"qa_bot_store_size_bytes ",
+		"qa_bot_rtm_connected ",
+	} {
+		if !strings.Contains(w.Body.String(), s) {
+			t.Errorf("Metrics don't contain %q", s)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package bot
+
+import (
+	"strings"
+)
+
+type markupKind int
+
+const (
+	markupText markupKind = iota
+	markupUser
+	markupChannel
+	markupUsergroup
+	markupSpecial
+	markupLink
+)
+
+// markup is a single piece of Slack message markup. For mentions Value holds
+// the referenced ID (or special command), for links it holds the URL.
+type markup struct {
+	Kind  markupKind
+	Value string
+	Label string
+	Raw   string
+}
+
+// tokenize splits Slack message text into plain text and "<...>" markup.
+// Slack escapes literal angle brackets as &lt; and &gt;, so every "<" in
+// the text starts markup; an unterminated one is kept as plain text.
+func tokenize(text string) []markup {
+	var tokens []markup
+	for len(text) > 0 {
+		start := strings.Index(text, "<")
+		if start < 0 {
+			tokens = append(tokens, markup{Kind: markupText, Raw: text})
+			break
+		}
+		end := strings.Index(text[start:], ">")
+		if end < 0 {
+			tokens = append(tokens, markup{Kind: markupText, Raw: text})
+			break
+		}
+		end += start
+		if start > 0 {
+			tokens = append(tokens, markup{Kind: markupText, Raw: text[:start]})
+		}
+		tokens = append(tokens, parseMarkup(text[start:end+1]))
+		text = text[end+1:]
+	}
+	return tokens
+}
+
+func parseMarkup(raw string) markup {
+	inner := raw[1 : len(raw)-1]
+	value, label := inner, ""
+	if i := strings.Index(inner, "|"); i >= 0 {
+		value, label = inner[:i], inner[i+1:]
+	}
+	t := markup{Value: value, Label: label, Raw: raw}
+	switch {
+	case strings.HasPrefix(value, "@"):
+		t.Kind = markupUser
+		t.Value = value[1:]
+	case strings.HasPrefix(value, "#"):
+		t.Kind = markupChannel
+		t.Value = value[1:]
+	case strings.HasPrefix(value, "!subteam^"):
+		t.Kind = markupUsergroup
+		t.Value = strings.TrimPrefix(value, "!subteam^")
+	case strings.HasPrefix(value, "!"):
+		t.Kind = markupSpecial
+		t.Value = value[1:]
+	case value == "":
+		t.Kind = markupText
+	default:
+		t.Kind = markupLink
+	}
+	return t
+}
+
+// formatMessage renders Slack markup for a repost: mentions are resolved to
+// plain names so nobody is pinged again, links are kept intact.
+func (b *Bot) formatMessage(text string) string {
+	var buf strings.Builder
+	for _, t := range tokenize(text) {
+		buf.WriteString(b.renderMarkup(t))
+	}
+	return buf.String()
+}
+
+func (b *Bot) renderMarkup(t markup) string {
+	switch t.Kind {
+	case markupUser:
+		if name, ok := b.users.Name(t.Value); ok {
+			return "@" + name
+		}
+		if t.Label != "" {
+			return "@" + strings.TrimPrefix(t.Label, "@")
+		}
+		return "@" + t.Value
+	case markupChannel:
+		if t.Label != "" {
+			return "#" + t.Label
+		}
+		if name, ok := b.channels[t.Value]; ok {
+			return "#" + name
+		}
+		return "#" + t.Value
+	case markupUsergroup:
+		if t.Label != "" {
+			return "@" + strings.TrimPrefix(t.Label, "@")
+		}
+		return "@" + t.Value
+	case markupSpecial:
+		switch t.Value {
+		case "here", "channel", "everyone":
+			return "@" + t.Value
+		}
+		if t.Label != "" {
+			return t.Label
+		}
+		return ""
+	}
+	return t.Raw
+}
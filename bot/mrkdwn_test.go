@@ -0,0 +1,43 @@
+package bot
+
+import (
+	"testing"
+)
+
+func TestFormatMessage(t *testing.T) {
+	b := &Bot{users: newUserCache(nil, nil), channels: map[string]string{"C024BE7LR": "jobs"}}
+	b.users.Set("U22KZA25S", "vasya")
+	b.users.Set("W11KZA007AB", "aid")
+
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{"", ""},
+		{"<@U22KZA25S> test", "@vasya test"},
+		{"test <@U22KZA25S>", "test @vasya"},
+		{"<@U22KZA25S> test <@W11KZA007AB>", "@vasya test @aid"},
+		{"<@U22KZA25S><@W11KZA007AB>", "@vasya@aid"},
+		{"<@U22KZA25S|vasya.p> test", "@vasya test"},
+		{"<@U99999999|@petya> test", "@petya test"},
+		{"<@U99999999> test", "@U99999999 test"},
+		{"see <#C024BE7LR>", "see #jobs"},
+		{"see <#C999|random>", "see #random"},
+		{"<!subteam^SAZ94GDB8|@qa-leads> please", "@qa-leads please"},
+		{"<!here> new job", "@here new job"},
+		{"<!channel|@channel> new job", "@channel new job"},
+		{"posted <!date^1392734382^{date}|Feb 18, 2014>", "posted Feb 18, 2014"},
+		{"job <http://hh.ru/vacancy/1|QA Lead> apply", "job <http://hh.ru/vacancy/1|QA Lead> apply"},
+		{"job <http://hh.ru/vacancy/1>", "job <http://hh.ru/vacancy/1>"},
+		{"cv to <mailto:hr@example.com|hr@example.com>", "cv to <mailto:hr@example.com|hr@example.com>"},
+		{"a &lt; b and c &gt; d", "a &lt; b and c &gt; d"},
+		{"unterminated <http://hh.ru", "unterminated <http://hh.ru"},
+	}
+
+	for _, v := range cases {
+		result := b.formatMessage(v.in)
+		if result != v.out {
+			t.Errorf("For string: %s, actual result: %v, expected: %v", v.in, result, v.out)
+		}
+	}
+}
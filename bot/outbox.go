@@ -0,0 +1,381 @@
+package bot
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	outboxBucket = "OUTBOX"
+
+	jobRepost = "repost"
+	jobDelete = "delete"
+	// jobExternal posts to a destination outside of the workspace.
+	jobExternal = "external"
+	// jobWebhook sends an event to a webhook of the config.
+	jobWebhook = "webhook"
+	// jobPost posts a message written by the bot, like a digest.
+	jobPost = "post"
+	// jobDM sends a direct message to the user, like a subscription alert.
+	jobDM = "dm"
+
+	// outboxLease is how long a job taken by a worker is hidden from others,
+	// so a job is only retried after a crash once the lease expires.
+	outboxLease       = time.Minute
+	outboxPoll        = 5 * time.Second
+	outboxMinBackoff  = 5 * time.Second
+	outboxMaxBackoff  = time.Hour
+	outboxMaxAttempts = 10
+)
+
+// job is a Slack call waiting in the outbox until it succeeds. A repost
+// carries the posting, which is saved for dedup after the first success.
+// An external post goes to the destination instead of a channel.
+type job struct {
+	ID          uint64    `json:"id"`
+	Kind        string    `json:"kind"`
+	Channel     string    `json:"channel"`
+	Destination string    `json:"destination,omitempty"`
+	Text        string    `json:"text,omitempty"`
+	Timestamp   string    `json:"ts,omitempty"`
+	User        string    `json:"user,omitempty"`
+	Posting     *posting  `json:"posting,omitempty"`
+	Attempts    int       `json:"attempts"`
+	NextTry     time.Time `json:"next_try"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// target is the channel or the destination of the job, for logs.
+func (j job) target() string {
+	if j.Kind == jobExternal || j.Kind == jobWebhook {
+		return j.Destination
+	}
+	if j.Kind == jobDM {
+		return j.User
+	}
+	return j.Channel
+}
+
+func jobKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+func putJob(bucket *bolt.Bucket, j job) error {
+	value, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(jobKey(j.ID), value)
+}
+
+// enqueue stores the jobs leased to the caller, who is expected to try them
+// right away.
+func enqueue(jobs []job, now time.Time, db *bolt.DB) ([]job, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		return addJobs(tx, jobs, now.Add(outboxLease))
+	})
+	return jobs, err
+}
+
+// schedule stores the jobs due right away and wakes up the outbox to run
+// them, so the caller doesn't wait for their delivery.
+func (b *Bot) schedule(jobs []job) error {
+	err := b.store.Update(func(tx *bolt.Tx) error {
+		return addJobs(tx, jobs, b.now())
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// enqueueRepost queues reposts of the text unless it was already reposted
+// or is waiting in the outbox. Both happen in one transaction, so workers
+// handling the same vacancy from different channels can't queue it twice.
+func enqueueRepost(text string, jobs []job, now time.Time, db *bolt.DB) ([]job, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		pending, err := hasPendingRepost(tx, text)
+		if err != nil {
+			return err
+		}
+		if pending || tx.Bucket([]byte(bucket)).Get([]byte(text)) != nil {
+			return errors.New(messageIsAlreadyPosted)
+		}
+		return addJobs(tx, jobs, now.Add(outboxLease))
+	})
+	return jobs, err
+}
+
+func addJobs(tx *bolt.Tx, jobs []job, nextTry time.Time) error {
+	bucket := tx.Bucket([]byte(outboxBucket))
+	for i := range jobs {
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		jobs[i].ID = id
+		jobs[i].NextTry = nextTry
+		if err := putJob(bucket, jobs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claimJobs leases the jobs due by now and returns them in order.
+func claimJobs(now time.Time, db *bolt.DB) ([]job, error) {
+	var result []job
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(outboxBucket))
+		jobs, err := readJobs(tx)
+		if err != nil {
+			return err
+		}
+		for _, j := range jobs {
+			if j.NextTry.After(now) {
+				continue
+			}
+			j.NextTry = now.Add(outboxLease)
+			if err := putJob(bucket, j); err != nil {
+				return err
+			}
+			result = append(result, j)
+		}
+		return nil
+	})
+	return result, err
+}
+
+func listJobs(db *bolt.DB) ([]job, error) {
+	var result []job
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		result, err = readJobs(tx)
+		return err
+	})
+	return result, err
+}
+
+func readJobs(tx *bolt.Tx) ([]job, error) {
+	var result []job
+	err := tx.Bucket([]byte(outboxBucket)).ForEach(func(k, v []byte) error {
+		var j job
+		if err := json.Unmarshal(v, &j); err != nil {
+			return err
+		}
+		result = append(result, j)
+		return nil
+	})
+	return result, err
+}
+
+// pendingRepost reports whether a repost of the text is still in the
+// outbox, so a duplicate isn't queued before the first one is delivered.
+// An unreadable outbox counts as no pending reposts, enqueueRepost reports it.
+func pendingRepost(text string, db *bolt.DB) bool {
+	var pending bool
+	db.View(func(tx *bolt.Tx) error {
+		var err error
+		pending, err = hasPendingRepost(tx, text)
+		return err
+	})
+	return pending
+}
+
+func hasPendingRepost(tx *bolt.Tx, text string) (bool, error) {
+	jobs, err := readJobs(tx)
+	if err != nil {
+		return false, err
+	}
+	for _, j := range jobs {
+		if j.Kind == jobRepost && j.Posting != nil && j.Posting.Text == text {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RunOutbox retries failed jobs and runs scheduled ones until stop is
+// closed.
+func (b *Bot) RunOutbox(stop <-chan struct{}) {
+	ticker := time.NewTicker(outboxPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-b.wake:
+		}
+		b.deliverDue()
+	}
+}
+
+// deliverDue runs the jobs of the outbox due by now.
+func (b *Bot) deliverDue() {
+	jobs, err := claimJobs(b.now(), b.store)
+	if err != nil {
+		b.log.Error("Can't read outbox", "error", err)
+		return
+	}
+	b.deliver(jobs)
+}
+
+// queuedError is returned for a delivery that failed but waits in the
+// outbox for a retry, so the message isn't lost.
+type queuedError struct {
+	cause error
+}
+
+func (e *queuedError) Error() string {
+	return "Queued for retry: " + e.cause.Error()
+}
+
+// deliver runs the jobs. Failed jobs stay in the outbox for a retry unless
+// the error is permanent. It returns the last permanent error, or else a
+// queuedError if a job waits for a retry.
+func (b *Bot) deliver(jobs []job) error {
+	var dropped, queued error
+	for _, j := range jobs {
+		var ts string
+		var err error
+		switch j.Kind {
+		case jobRepost:
+			ts, err = b.client.Repost(j.Channel, j.Text)
+		case jobDelete:
+			err = b.client.Delete(j.Channel, j.Timestamp)
+		case jobPost:
+			ts, err = b.client.Post(j.Channel, j.Text)
+		case jobDM:
+			err = b.client.SendDM(j.User, j.Text)
+		case jobExternal:
+			err = b.publish(j)
+		case jobWebhook:
+			err = b.callWebhook(j)
+		}
+		if err != nil {
+			if b.retry(j, err) {
+				queued = &queuedError{cause: err}
+			} else {
+				dropped = err
+			}
+			continue
+		}
+		b.complete(j, ts)
+	}
+	if dropped != nil {
+		return dropped
+	}
+	return queued
+}
+
+// complete removes a delivered job. The first delivered repost of a posting
+// saves it with a link to the repost and alerts subscribers.
+func (b *Bot) complete(j job, ts string) {
+	var first bool
+	var p posting
+	err := b.store.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket([]byte(outboxBucket)).Delete(jobKey(j.ID)); err != nil {
+			return err
+		}
+		if j.Kind != jobRepost || j.Posting == nil || tx.Bucket([]byte(bucket)).Get([]byte(j.Posting.Text)) != nil {
+			return nil
+		}
+		first = true
+		p = *j.Posting
+		p.Link = b.permalink(j.Channel, ts)
+		return putPosting(tx, p.Text, p)
+	})
+	if err != nil {
+		b.log.Error("Can't complete outbox job", "job", j.ID, "error", err)
+	}
+
+	switch j.Kind {
+	case jobRepost:
+		metricReposts.Inc(b.cfg.Workspace)
+		if first {
+			if b.dedup != nil {
+				if err := savePosted(p.Text, p, b.dedup); err != nil {
+					b.log.Error("Can't save posting to shared DB", "error", err)
+				}
+			}
+			b.recordEvent(eventReposted, p.Channel, p.User)
+			b.notifySubscribers(p, p.Link)
+			b.emit(webhookEvent{Event: eventJobReposted, Posting: &p})
+		}
+	case jobDelete:
+		metricDeletions.Inc(b.cfg.Workspace)
+		b.recordEvent(eventDeleted, j.Channel, j.User)
+		b.emit(webhookEvent{Event: eventDeletedInTarget, Channel: j.Channel, Timestamp: j.Timestamp, User: j.User})
+	case jobExternal:
+		metricExternal.Inc(j.Destination)
+	case jobWebhook:
+		b.recordDelivery(j, true)
+	}
+}
+
+// retry schedules the job after a backoff, or the delay Slack asked for,
+// and drops it after too many attempts or a permanent error. It reports
+// whether the job is kept for a retry.
+func (b *Bot) retry(j job, cause error) bool {
+	j.Attempts++
+	j.LastError = cause.Error()
+	delay, ok := retryDelay(cause, j.Attempts)
+	err := b.store.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(outboxBucket))
+		if !ok || j.Attempts >= outboxMaxAttempts {
+			return bucket.Delete(jobKey(j.ID))
+		}
+		j.NextTry = b.now().Add(delay)
+		return putJob(bucket, j)
+	})
+	if err != nil {
+		b.log.Error("Can't update outbox job", "job", j.ID, "error", err)
+		return false
+	}
+	if !ok || j.Attempts >= outboxMaxAttempts {
+		metricDropped.Inc(j.Kind)
+		switch {
+		case j.Kind == jobWebhook:
+			b.recordDelivery(j, false)
+		case j.Kind == jobRepost && j.Posting != nil && !alreadyPosted(j.Posting.Text, b.store) && !pendingRepost(j.Posting.Text, b.store):
+			b.release(*j.Posting)
+		}
+		b.log.Error("Delivery failed, giving up", "job", j.ID, "kind", j.Kind, "channel", j.target(), "attempts", j.Attempts, "error", cause)
+		return false
+	}
+	b.log.Warn("Delivery failed, will retry", "job", j.ID, "kind", j.Kind, "channel", j.target(), "attempts", j.Attempts, "next_try", j.NextTry, "error", cause)
+	return true
+}
+
+// retryDelay returns how long to wait before the next attempt and false if
+// the error won't go away by retrying.
+func retryDelay(err error, attempts int) (time.Duration, bool) {
+	switch e := err.(type) {
+	case *rateLimitError:
+		if e.RetryAfter > 0 {
+			return e.RetryAfter, true
+		}
+	case *apiError:
+		switch e.Code {
+		case "ratelimited", "internal_error", "fatal_error", "service_unavailable", "request_timeout":
+		default:
+			return 0, false
+		}
+	}
+	delay := outboxMinBackoff << uint(attempts-1)
+	if delay > outboxMaxBackoff || delay <= 0 {
+		delay = outboxMaxBackoff
+	}
+	return delay, true
+}
@@ -0,0 +1,113 @@
+package bot
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+func TestRetryDelay(t *testing.T) {
+	cases := []struct {
+		err      error
+		attempts int
+		delay    time.Duration
+		retry    bool
+	}{
+		{&rateLimitError{Method: "chat.postMessage", RetryAfter: 30 * time.Second}, 1, 30 * time.Second, true},
+		{&rateLimitError{Method: "chat.postMessage"}, 2, 10 * time.Second, true},
+		{&apiError{Method: "chat.postMessage", Code: "ratelimited"}, 3, 20 * time.Second, true},
+		{&apiError{Method: "chat.postMessage", Code: "channel_not_found"}, 1, 0, false},
+		{errors.New("connection reset by peer"), 1, 5 * time.Second, true},
+		{errors.New("connection reset by peer"), 40, time.Hour, true},
+	}
+
+	for _, v := range cases {
+		delay, retry := retryDelay(v.err, v.attempts)
+		if delay != v.delay || retry != v.retry {
+			t.Errorf("For error: %v, attempt %d, actual result: %v, %v, expected: %v, %v", v.err, v.attempts, delay, retry, v.delay, v.retry)
+		}
+	}
+}
+
+func TestFailedRepostIsRetried(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+
+	client := newFakeSlack()
+	client.fail("chat.postMessage", &rateLimitError{Method: "chat.postMessage", RetryAfter: time.Minute})
+	c := newTestBot(t, client, db, Config{Routes: []*Route{testRoute("C1", "C2")}})
+	text := "Вакансия тестировщика https://hh.ru/vacancy/" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	ev := &slack.MessageEvent{Msg: slack.Msg{Channel: "C1", User: "U1", Text: text}}
+
+	if err, ok := c.RepostMessage(ev).(*queuedError); !ok {
+		t.Fatalf("Rate limited repost should be queued, actual error: %v", err)
+	}
+	if alreadyPosted(text, db) {
+		t.Error("Posting is marked as posted before delivery")
+	}
+	if err := c.RepostMessage(ev); err == nil || err.Error() != messageIsAlreadyPosted {
+		t.Errorf("Pending posting should block duplicates, actual error: %v", err)
+	}
+
+	jobs, err := claimJobs(time.Now(), db)
+	if err != nil || len(jobs) != 0 {
+		t.Fatalf("Job shouldn't be due before Retry-After, actual: %v, %v", jobs, err)
+	}
+	jobs, _ = claimJobs(time.Now().Add(2*time.Minute), db)
+	if len(jobs) != 1 || jobs[0].Attempts != 1 {
+		t.Fatalf("Job should be due after Retry-After, actual: %v", jobs)
+	}
+	if err := c.deliver(jobs); err != nil {
+		t.Fatal("Can't deliver job: ", err)
+	}
+	if !alreadyPosted(text, db) || len(client.posts()["C2"]) != 1 {
+		t.Errorf("Posting isn't delivered, posts: %v", client.posts())
+	}
+	if left, _ := listJobs(db); len(left) != 0 {
+		t.Errorf("Outbox isn't empty: %v", left)
+	}
+}
+
+func TestPermanentErrorDropsJob(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+
+	client := newFakeSlack()
+	client.fail("chat.postMessage", &apiError{Method: "chat.postMessage", Code: "channel_not_found"})
+	c := newTestBot(t, client, db, Config{Routes: []*Route{testRoute("C1", "C2")}})
+	text := "Вакансия тестировщика https://hh.ru/vacancy/" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	err := c.RepostMessage(&slack.MessageEvent{Msg: slack.Msg{Channel: "C1", User: "U1", Text: text}})
+	if _, queued := err.(*queuedError); err == nil || queued {
+		t.Errorf("Permanent error should be returned, actual: %v", err)
+	}
+
+	if left, _ := listJobs(db); len(left) != 0 {
+		t.Errorf("Job with permanent error should be dropped: %v", left)
+	}
+	if alreadyPosted(text, db) || pendingRepost(text, db) {
+		t.Error("Undelivered posting should be reposted next time")
+	}
+}
+
+func TestSlackRetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "42")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	c := NewSlackClient("xoxb-"+t.Name(), server.URL+"/", nil)
+
+	_, repostErr := c.Repost("C1", "text")
+	_, historyErr := c.History("C1", "", 10)
+	for _, err := range []error{repostErr, historyErr} {
+		limit, ok := err.(*rateLimitError)
+		if !ok || limit.RetryAfter != 42*time.Second {
+			t.Errorf("Actual error: %v, expected rate limit with Retry-After", err)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+const defaultFormat = "{{.Text}}"
+
+// Rules decide whether a message is a job posting. A list left out of the
+// config falls back to the built-in defaults, an empty list disables it.
+type Rules struct {
+	TextKeywords []string `json:"text_keywords"`
+	LinkKeywords []string `json:"link_keywords"`
+	Exclusions   []string `json:"exclusions"`
+	Patterns     []string `json:"patterns"`
+
+	regexps []*regexp.Regexp
+}
+
+// Route reposts job postings from any of its source channels to every
+// target channel and external destination, using its own rules and message
+// format. A route with categories only takes postings tagged with at least
+// one of them.
+type Route struct {
+	Name       string         `json:"name"`
+	From       []string       `json:"from"`
+	To         []string       `json:"to"`
+	External   []*Destination `json:"external"`
+	Rules      Rules          `json:"rules"`
+	Categories []string       `json:"categories"`
+	Format     string         `json:"format"`
+
+	fromIDs  []string
+	toIDs    []string
+	template *template.Template
+}
+
+// repost is the data available to a route format template. Workspace is
+// the URL of the workspace the posting comes from.
+type repost struct {
+	Text      string
+	Author    string
+	Channel   string
+	Workspace string
+	Tags      []string
+	Fields    fields
+}
+
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg := &Config{}
+	if err := json.NewDecoder(f).Decode(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Categories == nil {
+		cfg.Categories = DefaultCategories()
+	}
+	return cfg, nil
+}
+
+// ReadSecret returns the secret given either as the value or in the file,
+// the content of the file trimmed of spaces.
+func ReadSecret(value, file string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+	if value != "" {
+		return "", errors.New("Set either the secret or the file with it, not both")
+	}
+	secret, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(secret)), nil
+}
+
+func (r *Rules) compile() error {
+	if r.TextKeywords == nil {
+		r.TextKeywords = textKeywords
+	}
+	if r.LinkKeywords == nil {
+		r.LinkKeywords = linkKeywords
+	}
+	if r.Exclusions == nil {
+		r.Exclusions = exclusions
+	}
+	if r.Patterns == nil {
+		r.Patterns = []string{regexURL, regexEmail}
+	}
+	r.regexps = nil
+	for _, p := range r.Patterns {
+		rgxp, err := regexp.Compile(p)
+		if err != nil {
+			return err
+		}
+		r.regexps = append(r.regexps, rgxp)
+	}
+	return nil
+}
+
+func (r *Route) compile() error {
+	if len(r.From) == 0 || len(r.To) == 0 {
+		return errors.New("route " + r.Name + " needs source and target channels")
+	}
+	if err := r.Rules.compile(); err != nil {
+		return err
+	}
+	if r.Format == "" {
+		r.Format = defaultFormat
+	}
+	tmpl, err := template.New(r.Name).Parse(r.Format)
+	if err != nil {
+		return err
+	}
+	r.template = tmpl
+	names := make(map[string]bool)
+	for _, d := range r.External {
+		if err := d.compile(); err != nil {
+			return err
+		}
+		if names[d.Name] {
+			return errors.New("destination " + d.Name + " is defined twice")
+		}
+		names[d.Name] = true
+	}
+	return nil
+}
+
+// resolve maps channel names or IDs of the route to IDs.
+func (r *Route) resolve(channels ChannelList) error {
+	var err error
+	r.fromIDs, err = channels.ids(r.From)
+	if err != nil {
+		return err
+	}
+	r.toIDs, err = channels.ids(r.To)
+	return err
+}
+
+func (r *Route) isSource(id string) bool {
+	return contains(r.fromIDs, id)
+}
+
+func (r *Route) isTarget(id string) bool {
+	return contains(r.toIDs, id)
+}
+
+func (r *Route) accepts(tags []string) bool {
+	return hasCategory(r.Categories, tags)
+}
+
+// hasCategory reports whether any of the tags is one of the categories, no
+// categories take everything.
+func hasCategory(categories, tags []string) bool {
+	if len(categories) == 0 {
+		return true
+	}
+	for _, tag := range tags {
+		if contains(categories, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Route) format(msg repost) (string, error) {
+	var buf bytes.Buffer
+	err := r.template.Execute(&buf, msg)
+	return buf.String(), err
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
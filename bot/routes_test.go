@@ -0,0 +1,132 @@
+package bot
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/nlopes/slack"
+)
+
+func TestLoadConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "routes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"routes": [
+		{"name": "manual", "from": ["general", "random"], "to": ["jobs-manual"]},
+		{"name": "automation", "from": ["automation"], "to": ["jobs-automation"],
+		 "rules": {"text_keywords": ["selenium"], "exclusions": []},
+		 "format": "{{.Text}} (from #{{.Channel}})"}
+	]}`)
+	f.Close()
+
+	cfg, err := LoadConfig(f.Name())
+	if err != nil {
+		t.Fatal("Can't load config: ", err)
+	}
+	for _, rt := range cfg.Routes {
+		if err := rt.compile(); err != nil {
+			t.Fatal("Can't compile route: ", err)
+		}
+	}
+
+	manual, automation := cfg.Routes[0], cfg.Routes[1]
+	if !reflect.DeepEqual(manual.Rules.TextKeywords, textKeywords) || manual.Format != defaultFormat {
+		t.Error("Route without rules should use defaults")
+	}
+	if !reflect.DeepEqual(automation.Rules.TextKeywords, []string{"selenium"}) {
+		t.Errorf("Actual keywords: %v, expected: [selenium]", automation.Rules.TextKeywords)
+	}
+	if len(automation.Rules.Exclusions) != 0 {
+		t.Errorf("Empty exclusions should stay empty, actual: %v", automation.Rules.Exclusions)
+	}
+	if !reflect.DeepEqual(automation.Rules.LinkKeywords, linkKeywords) {
+		t.Error("Omitted link keywords should use defaults")
+	}
+
+	channels := ChannelList{
+		{ID: "C1", Name: "general", Member: true},
+		{ID: "C2", Name: "random", Member: true},
+		{ID: "C3", Name: "automation", Member: true},
+		{ID: "C4", Name: "jobs-manual", Member: true},
+	}
+	if err := manual.resolve(channels); err != nil {
+		t.Error("Can't resolve route: ", err)
+	}
+	ids := append([]string{}, manual.fromIDs...)
+	sort.Strings(ids)
+	if !reflect.DeepEqual(ids, []string{"C1", "C2"}) {
+		t.Errorf("Actual source IDs: %v, expected: [C1 C2]", ids)
+	}
+	if err := automation.resolve(channels); err == nil {
+		t.Error("Route with unknown target channel shouldn't resolve")
+	}
+}
+
+func TestRepostMessageEvaluatedPerRoute(t *testing.T) {
+	db, err := bolt.Open("test.db", 0600, nil)
+	if err != nil {
+		t.Fatal("Can't open DB: ", err)
+	}
+	defer db.Close()
+	err = createBuckets(db)
+	if err != nil {
+		t.Fatal("Can't create bucket: ", err)
+	}
+
+	manual := testRoute("C1", "T1")
+	automation := &Route{
+		Name:       "automation",
+		From:       []string{"general"},
+		To:         []string{"jobs-automation"},
+		Rules:      Rules{TextKeywords: []string{"selenium"}, LinkKeywords: []string{}},
+		Categories: []string{"automation"},
+		Format:     "[{{range .Tags}}{{.}}{{end}}] {{.Text}} #{{.Channel}}",
+	}
+	if err := automation.compile(); err != nil {
+		t.Fatal(err)
+	}
+	automation.fromIDs = []string{"C1", "C2"}
+	automation.toIDs = []string{"T2", "T3"}
+
+	recorder := newFakeSlack()
+	client := newTestBot(t, recorder, db, Config{Routes: []*Route{manual, automation}, Categories: DefaultCategories()})
+	client.channels = map[string]string{"C1": "general", "C2": "automation"}
+
+	text := "selenium http://example.com " + strconv.FormatInt(time.Now().UnixNano(), 10)
+	err = client.RepostMessage(&slack.MessageEvent{Msg: slack.Msg{Channel: "C1", Text: text}})
+	if err != nil {
+		t.Fatal("Message should be reposted: ", err)
+	}
+	expected := map[string][]string{
+		"T2": {"[automation] " + text + " #general"},
+		"T3": {"[automation] " + text + " #general"},
+	}
+	if !reflect.DeepEqual(recorder.posts(), expected) {
+		t.Errorf("Actual posts: %v, expected: %v", recorder.posts(), expected)
+	}
+
+	var p posting
+	err = db.View(func(tx *bolt.Tx) error {
+		return json.Unmarshal(tx.Bucket([]byte(bucket)).Get([]byte(text)), &p)
+	})
+	if err != nil {
+		t.Fatal("Can't read posting: ", err)
+	}
+	if p.Channel != "C1" || !reflect.DeepEqual(p.Tags, []string{"automation"}) {
+		t.Errorf("Actual posting: %+v", p)
+	}
+
+	err = client.RepostMessage(&slack.MessageEvent{Msg: slack.Msg{Channel: "C9", Text: text}})
+	if err == nil || err.Error() != wrongChannelID {
+		t.Errorf("Actual error: %v, expected: %s", err, wrongChannelID)
+	}
+}
@@ -0,0 +1,47 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "0 9 * * mon"} {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("For schedule: %q, error shouldn't be nil", spec)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	// Wednesday
+	from := time.Date(2018, time.March, 14, 10, 30, 15, 0, time.UTC)
+
+	cases := []struct {
+		spec string
+		res  time.Time
+	}{
+		{"* * * * *", time.Date(2018, time.March, 14, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2018, time.March, 14, 10, 45, 0, 0, time.UTC)},
+		{"0 10 * * 1", time.Date(2018, time.March, 19, 10, 0, 0, 0, time.UTC)},
+		{"0 9 * * 0", time.Date(2018, time.March, 18, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 7", time.Date(2018, time.March, 18, 9, 0, 0, 0, time.UTC)},
+		{"0 9-17/4 * * 1-5", time.Date(2018, time.March, 14, 13, 0, 0, 0, time.UTC)},
+		{"30 18 1,15 * *", time.Date(2018, time.March, 15, 18, 30, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 13 * 5", time.Date(2018, time.March, 16, 12, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, v := range cases {
+		s, err := parseSchedule(v.spec)
+		if err != nil {
+			t.Errorf("For schedule: %s, unexpected error: %s", v.spec, err)
+			continue
+		}
+		result := s.next(from)
+		if !result.Equal(v.res) {
+			t.Errorf("For schedule: %s, actual result: %v, expected: %v", v.spec, result, v.res)
+		}
+	}
+}
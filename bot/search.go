@@ -0,0 +1,319 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	// searchBucket is the inverted index of postings: a key term/id holds
+	// the number of occurrences of the term in the posting and #id the key
+	// of the posting. Terms are letters and digits only, so keys don't clash.
+	searchBucket = "SEARCH_INDEX"
+	// SearchLimit is the number of results of a search in Slack.
+	SearchLimit = 10
+)
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"for": true, "from": true, "in": true, "is": true, "it": true, "of": true, "on": true, "or": true,
+	"the": true, "to": true, "we": true, "with": true, "you": true, "our": true, "your": true,
+	"http": true, "https": true, "www": true,
+	"а": true, "в": true, "во": true, "и": true, "к": true, "на": true, "не": true, "о": true,
+	"об": true, "от": true, "по": true, "с": true, "со": true, "у": true, "для": true, "из": true,
+	"за": true, "или": true, "как": true, "но": true, "что": true, "это": true, "мы": true, "вы": true,
+}
+
+// searchTerms splits text into terms: words in lower case with ё as е,
+// without stop words, stemmed as Russian or English words.
+func searchTerms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var result []string
+	for _, word := range words {
+		word = strings.ReplaceAll(word, "ё", "е")
+		if stopWords[word] {
+			continue
+		}
+		result = append(result, stem(word))
+	}
+	return result
+}
+
+func stem(word string) string {
+	for _, r := range word {
+		if unicode.Is(unicode.Cyrillic, r) {
+			return stemRussian(word)
+		}
+	}
+	for _, r := range word {
+		if r < 'a' || r > 'z' {
+			return word
+		}
+	}
+	return stemEnglish(word)
+}
+
+// stemEnglish removes plural and common verb endings.
+func stemEnglish(word string) string {
+	switch {
+	case strings.HasSuffix(word, "sses"):
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "ies") && len(word) > 4:
+		word = word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us") && len(word) > 3:
+		word = word[:len(word)-1]
+	}
+	for _, suffix := range []string{"ing", "ed", "er", "ly"} {
+		if s := strings.TrimSuffix(word, suffix); s != word && len(s) >= 3 && strings.ContainsAny(s, "aeiouy") {
+			return s
+		}
+	}
+	return word
+}
+
+const russianVowels = "аеиоуыэюя"
+
+var (
+	russianGerund           = []string{"ившись", "ывшись", "ивши", "ывши", "ив", "ыв"}
+	russianGerundAfterA     = []string{"вшись", "вши", "в"}
+	russianReflexive        = []string{"ся", "сь"}
+	russianAdjective        = []string{"ими", "ыми", "его", "ого", "ему", "ому", "ее", "ие", "ые", "ое", "ей", "ий", "ый", "ой", "ем", "им", "ым", "ом", "их", "ых", "ую", "юю", "ая", "яя", "ою", "ею"}
+	russianParticiple       = []string{"ивш", "ывш", "ующ"}
+	russianParticipleAfterA = []string{"ем", "нн", "вш", "ющ", "щ"}
+	russianVerb             = []string{"ила", "ыла", "ена", "ейте", "уйте", "ите", "или", "ыли", "ей", "уй", "ил", "ыл", "им", "ым", "ен", "ило", "ыло", "ено", "ят", "ует", "уют", "ит", "ыт", "ены", "ить", "ыть", "ишь", "ую", "ю"}
+	russianVerbAfterA       = []string{"ла", "на", "ете", "йте", "ли", "й", "л", "ем", "н", "ло", "но", "ет", "ют", "ны", "ть", "ешь", "нно"}
+	russianNoun             = []string{"а", "ев", "ов", "ие", "ье", "е", "иями", "ями", "ами", "еи", "ии", "и", "ией", "ей", "ой", "ий", "й", "иям", "ям", "ием", "ем", "ам", "ом", "о", "у", "ах", "иях", "ях", "ы", "ь", "ию", "ью", "ю", "ия", "ья", "я"}
+)
+
+// stemRussian is a light version of the Snowball Russian stemmer: it
+// removes endings of gerunds, adjectives and participles, verbs and nouns
+// after the first vowel, and a doubled н.
+func stemRussian(word string) string {
+	rv := strings.IndexAny(word, russianVowels)
+	if rv < 0 {
+		return word
+	}
+	rv += len("а")
+	if s, ok := cutEnding(word, rv, russianGerund, russianGerundAfterA); ok {
+		word = s
+	} else {
+		word, _ = cutEnding(word, rv, russianReflexive, nil)
+		if s, ok := cutEnding(word, rv, russianAdjective, nil); ok {
+			word, _ = cutEnding(s, rv, russianParticiple, russianParticipleAfterA)
+		} else if s, ok := cutEnding(word, rv, russianVerb, russianVerbAfterA); ok {
+			word = s
+		} else {
+			word, _ = cutEnding(word, rv, russianNoun, nil)
+		}
+	}
+	word, _ = cutEnding(word, rv, []string{"и"}, nil)
+	word, _ = cutEnding(word, rv, []string{"ь"}, nil)
+	if strings.HasSuffix(word, "нн") {
+		word = strings.TrimSuffix(word, "н")
+	}
+	return word
+}
+
+// cutEnding removes the longest of the endings that starts at rv or later;
+// endings afterA count only after а or я, which stays.
+func cutEnding(word string, rv int, endings, afterA []string) (string, bool) {
+	best := ""
+	for _, e := range endings {
+		if len(e) > len(best) && strings.HasSuffix(word, e) && len(word)-len(e) >= rv {
+			best = e
+		}
+	}
+	for _, e := range afterA {
+		s := strings.TrimSuffix(word, e)
+		if len(e) > len(best) && s != word && len(s) >= rv && (strings.HasSuffix(s, "а") || strings.HasSuffix(s, "я")) {
+			best = e
+		}
+	}
+	return word[:len(word)-len(best)], best != ""
+}
+
+// documentTerms counts terms of the posting: its text without Slack markup,
+// author, company and tags.
+func documentTerms(p posting) map[string]int {
+	text := strings.Join(append([]string{plainText(p.Text), p.Author, p.Fields.Company}, p.Tags...), " ")
+	counts := make(map[string]int)
+	for _, term := range searchTerms(text) {
+		counts[term]++
+	}
+	return counts
+}
+
+// indexPosting adds the posting stored under the key to the search index.
+func indexPosting(tx *bolt.Tx, key string, p posting) error {
+	index := tx.Bucket([]byte(searchBucket))
+	id := postingID(key)
+	for term, n := range documentTerms(p) {
+		if err := index.Put([]byte(term+"/"+id), []byte(strconv.Itoa(n))); err != nil {
+			return err
+		}
+	}
+	return index.Put([]byte("#"+id), []byte(key))
+}
+
+// unindexPosting removes the posting stored under the key from the index.
+func unindexPosting(tx *bolt.Tx, key string, p posting) error {
+	index := tx.Bucket([]byte(searchBucket))
+	id := postingID(key)
+	for term := range documentTerms(p) {
+		if err := index.Delete([]byte(term + "/" + id)); err != nil {
+			return err
+		}
+	}
+	return index.Delete([]byte("#" + id))
+}
+
+// findPostingByID returns the posting with the ID from the index, or nil.
+func findPostingByID(id string, db *bolt.DB) (*posting, error) {
+	var result *posting
+	err := db.View(func(tx *bolt.Tx) error {
+		index := tx.Bucket([]byte(searchBucket))
+		if index == nil {
+			return errors.New("DB has no search index, start the bot once to build it")
+		}
+		key := index.Get([]byte("#" + id))
+		if key == nil {
+			return nil
+		}
+		var p posting
+		if err := json.Unmarshal(tx.Bucket([]byte(bucket)).Get(key), &p); err != nil {
+			return err
+		}
+		result = &p
+		return nil
+	})
+	return result, err
+}
+
+// indexPostings builds the index for postings saved before it appeared.
+// Records from older versions hold plain text and are skipped.
+func indexPostings(tx *bolt.Tx) error {
+	return tx.Bucket([]byte(bucket)).ForEach(func(k, v []byte) error {
+		var p posting
+		if json.Unmarshal(v, &p) != nil {
+			return nil
+		}
+		return indexPosting(tx, string(k), p)
+	})
+}
+
+// searchPostings returns up to limit postings with every word of the
+// query, ranked by TF-IDF and then newest first.
+func searchPostings(query string, limit int, db *bolt.DB) ([]posting, error) {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, term := range searchTerms(plainText(query)) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	if len(terms) == 0 {
+		return nil, errors.New("Specify words to search for")
+	}
+
+	type match struct {
+		posting
+		score float64
+	}
+	var matches []match
+	err := db.View(func(tx *bolt.Tx) error {
+		index := tx.Bucket([]byte(searchBucket))
+		if index == nil {
+			return errors.New("DB has no search index, start the bot once to build it")
+		}
+		postings := tx.Bucket([]byte(bucket))
+		total := float64(postings.Stats().KeyN)
+		var scores map[string]float64
+		for _, term := range terms {
+			prefix := term + "/"
+			found := make(map[string]float64)
+			cur := index.Cursor()
+			for k, v := cur.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, v = cur.Next() {
+				n, _ := strconv.Atoi(string(v))
+				found[strings.TrimPrefix(string(k), prefix)] = float64(n)
+			}
+			idf := math.Log(1 + total/math.Max(1, float64(len(found))))
+			next := make(map[string]float64)
+			for id, n := range found {
+				if score, ok := scores[id]; ok || scores == nil {
+					next[id] = score + n*idf
+				}
+			}
+			scores = next
+		}
+		for id, score := range scores {
+			var p posting
+			if json.Unmarshal(postings.Get(index.Get([]byte("#"+id))), &p) == nil {
+				matches = append(matches, match{p, score})
+			}
+		}
+		return nil
+	})
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].Time.After(matches[j].Time)
+	})
+	var result []posting
+	for i := 0; i < len(matches) && i < limit; i++ {
+		result = append(result, matches[i].posting)
+	}
+	return result, err
+}
+
+// formatResults lists postings with their date, author, first line and a
+// link to the repost.
+func formatResults(postings []posting) string {
+	if len(postings) == 0 {
+		return "Nothing found"
+	}
+	var lines []string
+	for i, p := range postings {
+		line := fmt.Sprintf("%d. %s", i+1, p.Time.UTC().Format(dateLayout))
+		if p.Author != "" {
+			line += " @" + p.Author
+		}
+		line += " " + preview(plainText(p.Text))
+		if p.Link != "" {
+			line += "\n" + p.Link
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Search returns up to limit postings matching the query, formatted like
+// replies to the search command.
+func Search(query string, limit int, db *bolt.DB) (string, error) {
+	postings, err := searchPostings(query, limit, db)
+	if err != nil {
+		return "", err
+	}
+	return formatResults(postings), nil
+}
+
+// searchReply answers a search in a DM or a slash command like
+// "/jobsearch yandex" with the best matching reposts.
+func (b *Bot) searchReply(query string) string {
+	results, err := Search(query, SearchLimit, b.store)
+	if err != nil {
+		return "Can't search: " + err.Error()
+	}
+	return results
+}
@@ -0,0 +1,154 @@
+package bot
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/nlopes/slack"
+)
+
+func TestSearchTerms(t *testing.T) {
+	cases := []struct {
+		text, expected string
+	}{
+		{"Testers and testing, tested by a tester", "test test test test"},
+		{"Companies: company", "company company"},
+		{"Вакансия, вакансии, вакансий, вакансию, вакансиями", "ваканс ваканс ваканс ваканс ваканс"},
+		{"Ищем тестировщика в Яндекс, опыт тестирования", "ищ тестировщик яндекс оп тестирован"},
+		{"Тестировщики для Яндекса", "тестировщик яндекс"},
+		{"Удалённая работа в Москве", "удален работ москв"},
+		{"QA в yandex.ru, 3000 USD", "qa yandex ru 3000 usd"},
+	}
+
+	for _, v := range cases {
+		if actual := strings.Join(searchTerms(v.text), " "); actual != v.expected {
+			t.Errorf("For text %q, actual: %q, expected: %q", v.text, actual, v.expected)
+		}
+	}
+}
+
+func seedSearch(t *testing.T, db *bolt.DB) {
+	for _, p := range []posting{
+		{Text: "Manual QA in Yandex, office in Moscow", Author: "vasya", Link: "https://qa.slack.com/archives/C1/p1", Time: time.Date(2018, 2, 10, 9, 0, 0, 0, time.UTC)},
+		{Text: "Ищем тестировщика в Яндекс, удалённо", Author: "petya", Link: "https://qa.slack.com/archives/C1/p2", Time: time.Date(2018, 3, 1, 9, 0, 0, 0, time.UTC)},
+		{Text: "SDET, Selenium and Java. Selenium grid, Selenium IDE", Fields: fields{Company: "Kaspersky"}, Link: "https://qa.slack.com/archives/C1/p3", Time: time.Date(2018, 3, 10, 9, 0, 0, 0, time.UTC)},
+		{Text: "Mobile SDET, Appium and Selenium", Tags: []string{"mobile"}, Link: "https://qa.slack.com/archives/C1/p4", Time: time.Date(2018, 3, 15, 9, 0, 0, 0, time.UTC)},
+	} {
+		if err := savePosted(p.Text, p, db); err != nil {
+			t.Fatal("Can't save posting: ", err)
+		}
+	}
+}
+
+func TestSearchPostings(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+	seedSearch(t, db)
+
+	cases := []struct {
+		query string
+		links []string
+		err   string
+	}{
+		{"selenium", []string{"p3", "p4"}, ""},
+		{"SDET mobile", []string{"p4"}, ""},
+		{"yandex", []string{"p1"}, ""},
+		{"яндекса", []string{"p2"}, ""},
+		{"удаленно", []string{"p2"}, ""},
+		{"тестировщики", []string{"p2"}, ""},
+		{"kaspersky", []string{"p3"}, ""},
+		{"@vasya", []string{"p1"}, ""},
+		{"selenium python", nil, ""},
+		{"в и the", nil, "Specify words to search for"},
+	}
+	for _, v := range cases {
+		postings, err := searchPostings(v.query, SearchLimit, db)
+		var links []string
+		for _, p := range postings {
+			links = append(links, strings.TrimPrefix(p.Link, "https://qa.slack.com/archives/C1/"))
+		}
+		if strings.Join(links, " ") != strings.Join(v.links, " ") || v.err == "" && err != nil || v.err != "" && (err == nil || err.Error() != v.err) {
+			t.Errorf("For query %q, actual results: %v, error: %v", v.query, links, err)
+		}
+	}
+
+	if postings, _ := searchPostings("selenium", 1, db); len(postings) != 1 {
+		t.Errorf("Actual number of results with limit: %d", len(postings))
+	}
+
+	// Saving a posting again replaces its terms.
+	if err := savePosted("Mobile SDET, Appium and Selenium", posting{Text: "Mobile SDET, Appium and Selenium", Author: "kolya"}, db); err != nil {
+		t.Fatal("Can't save posting: ", err)
+	}
+	if postings, _ := searchPostings("kolya", SearchLimit, db); len(postings) != 1 {
+		t.Errorf("Updated posting isn't found: %+v", postings)
+	}
+	if postings, _ := searchPostings("mobile", SearchLimit, db); len(postings) != 1 || postings[0].Author != "kolya" {
+		t.Errorf("Actual results for old terms: %+v", postings)
+	}
+}
+
+func TestSearchIndexIsBuiltForOldPostings(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+	err := db.Update(func(tx *bolt.Tx) error {
+		value, _ := json.Marshal(posting{Text: "Automation QA, Python"})
+		tx.Bucket([]byte(bucket)).Put([]byte("Automation QA, Python"), value)
+		tx.Bucket([]byte(bucket)).Put([]byte("legacy python"), []byte("legacy python"))
+		return tx.DeleteBucket([]byte(searchBucket))
+	})
+	if err != nil {
+		t.Fatal("Can't prepare DB: ", err)
+	}
+	if _, err := searchPostings("python", SearchLimit, db); err == nil {
+		t.Error("Search without index should fail")
+	}
+
+	if err := createBuckets(db); err != nil {
+		t.Fatal("Can't create buckets: ", err)
+	}
+	if postings, err := searchPostings("python", SearchLimit, db); err != nil || len(postings) != 1 || postings[0].Text != "Automation QA, Python" {
+		t.Errorf("Actual results: %+v, error: %v", postings, err)
+	}
+}
+
+func TestSearchCommands(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+	seedSearch(t, db)
+
+	recorder := newFakeSlack()
+	b := newTestBot(t, recorder, db, Config{})
+	expected := "1. 2018-03-01 @petya Ищем тестировщика в Яндекс, удалённо\nhttps://qa.slack.com/archives/C1/p2"
+
+	err := b.HandleCommand(&slack.MessageEvent{Msg: slack.Msg{Channel: "D1", User: "U1", Text: "search тестировщик"}})
+	if err != nil {
+		t.Fatal("Can't handle command: ", err)
+	}
+	if replies := recorder.posts()["D1"]; len(replies) != 1 || replies[0] != expected {
+		t.Errorf("Actual DM replies: %q", replies)
+	}
+
+	handler := b.slashCommandHandler("secret", b.searchReply)
+	for text, reply := range map[string]string{
+		"тестировщик": expected,
+		"python":      "Nothing found",
+		"":            "Can't search: Specify words to search for",
+	} {
+		form := url.Values{"token": {"secret"}, "text": {text}}
+		req := httptest.NewRequest("POST", "/slack/search", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		var resp map[string]string
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp["response_type"] != "ephemeral" || resp["text"] != reply {
+			t.Errorf("For text %q, actual response: %v", text, resp)
+		}
+	}
+}
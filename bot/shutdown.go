@@ -0,0 +1,78 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+// ShutdownTimeout limits every step of the shutdown, like closing the RTM
+// connection in Disconnect.
+const ShutdownTimeout = 10 * time.Second
+
+// serve handles RTM events until ctx is done or Slack rejects the
+// credentials. Messages are passed to the dispatcher; the caller stops it
+// to finish the ones already queued.
+func (b *Bot) serve(ctx context.Context, events <-chan slack.RTMEvent) error {
+	for {
+		select {
+		case <-ctx.Done():
+			b.log.Info("Shutting down")
+			return nil
+
+		case msg := <-events:
+			metricEvents.Inc(msg.Type)
+			b.trackConnection(msg.Data)
+			switch ev := msg.Data.(type) {
+			case *slack.MessageEvent:
+				b.messages.Dispatch(ev)
+
+			case *slack.ConnectedEvent:
+				b.log.Info("Connected to Slack", "connections", ev.ConnectionCount)
+
+			case *slack.TeamJoinEvent:
+				b.users.Set(ev.User.ID, ev.User.Name)
+
+			case *slack.UserChangeEvent:
+				b.users.Set(ev.User.ID, ev.User.Name)
+
+			case *slack.RTMError:
+				b.log.Error("RTM error", "error", ev.Error())
+
+			case *slack.InvalidAuthEvent:
+				b.log.Error(invalidCredentials)
+				return errors.New(invalidCredentials)
+			}
+		}
+	}
+}
+
+// Disconnect closes the RTM connection. The library reports it with an
+// event, so events are drained until it arrives or the timeout expires.
+func Disconnect(rtm *slack.RTM, log *slog.Logger) {
+	done := make(chan error, 1)
+	go func() {
+		done <- rtm.Disconnect()
+	}()
+	timeout := time.After(ShutdownTimeout)
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				// Not connected, nothing to wait for.
+				return
+			}
+			done = nil
+		case msg := <-rtm.IncomingEvents:
+			if _, ok := msg.Data.(*slack.DisconnectedEvent); ok {
+				return
+			}
+		case <-timeout:
+			log.Warn("Timed out disconnecting from Slack")
+			return
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package bot
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nlopes/slack"
+)
+
+func TestRunStops(t *testing.T) {
+	cases := []struct {
+		event  slack.RTMEvent
+		cancel bool
+		err    string
+	}{
+		{slack.RTMEvent{Type: "invalid_auth", Data: &slack.InvalidAuthEvent{}}, false, invalidCredentials},
+		{slack.RTMEvent{Type: "message", Data: &slack.MessageEvent{Msg: slack.Msg{Channel: "C1", Text: "hello"}}}, true, ""},
+	}
+
+	for _, v := range cases {
+		db := openTestDB(t)
+		b := newTestBot(t, newFakeSlack(), db, Config{Routes: []*Route{testRoute("C1", "C2")}})
+		events := make(chan slack.RTMEvent)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- b.Run(ctx, events)
+		}()
+		// The channel isn't buffered, so the event is taken by the bot
+		// before it is stopped.
+		events <- v.event
+		if v.cancel {
+			cancel()
+		}
+		if err := <-done; (err == nil && v.err != "") || (err != nil && err.Error() != v.err) {
+			t.Errorf("For event %s, actual error: %v, expected: %q", v.event.Type, err, v.err)
+		}
+		cancel()
+		closeTestDB(db)
+	}
+}
+
+func TestRunDigestStops(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+	d := &Digest{Schedule: "0 10 * * 1", Channel: "digest"}
+	if err := d.compile(); err != nil {
+		t.Fatal("Can't compile digest: ", err)
+	}
+	b := newTestBot(t, newFakeSlack(), db, Config{})
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		b.RunDigest(d, stop)
+		close(done)
+	}()
+	close(stop)
+	<-done
+}
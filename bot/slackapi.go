@@ -0,0 +1,308 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+const channelsPageSize = "200"
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// Slacker is everything the bot asks Slack for. Channels are referred to
+// by ID and messages by channel and timestamp.
+type Slacker interface {
+	UserDirectory
+	// AuthTest returns the workspace and the user the token belongs to.
+	AuthTest() (*AuthInfo, error)
+	// Channels returns public channels and private ones the bot is in.
+	Channels() (ChannelList, error)
+	// History returns up to limit messages newer than oldest, newest first.
+	History(channel, oldest string, limit int) ([]slack.Msg, error)
+	// Repost posts the text and returns the timestamp of the new message.
+	Repost(channel, text string) (string, error)
+	// Post posts a message written by the bot, like a digest, with markdown.
+	Post(channel, text string) (string, error)
+	Update(channel, timestamp, text string) error
+	Delete(channel, timestamp string) error
+	AddReaction(channel, timestamp, name string) error
+	RemoveReaction(channel, timestamp, name string) error
+	// OpenIM returns the ID of the direct message channel with the user.
+	OpenIM(user string) (string, error)
+	// SendDM posts the text to the user like Post.
+	SendDM(user, text string) error
+}
+
+// SlackClient is the Slacker over the Web API. Calls go to the API URL and
+// through the HTTP client of the SlackClient; Slack is the vendored client
+// of the token for the RTM connection, which always starts at
+// slack.SLACK_API.
+type SlackClient struct {
+	Slack *slack.Client
+	Token string
+	// Workspace labels metrics of the calls.
+	Workspace string
+
+	apiURL string
+	http   *http.Client
+}
+
+// NewSlackClient creates a client for the token calling the Web API at
+// apiURL, slack.SLACK_API outside of tests, with the HTTP client or a
+// default one if it is nil.
+func NewSlackClient(token, apiURL string, client *http.Client) SlackClient {
+	if client == nil {
+		client = httpClient
+	}
+	return SlackClient{Slack: slack.New(token), Token: token, apiURL: apiURL, http: client}
+}
+
+// AuthInfo is the reply of auth.test.
+type AuthInfo struct {
+	// URL of the workspace, e.g. https://team.slack.com/.
+	URL    string
+	Team   string
+	User   string
+	UserID string
+	// Scopes granted to the token, empty when Slack doesn't report them.
+	Scopes []string
+}
+
+func (c SlackClient) AuthTest() (*AuthInfo, error) {
+	var resp struct {
+		URL    string `json:"url"`
+		Team   string `json:"team"`
+		User   string `json:"user"`
+		UserID string `json:"user_id"`
+	}
+	reply, err := c.callSlack("auth.test", url.Values{}, &resp)
+	if err != nil {
+		return &AuthInfo{}, err
+	}
+	a := &AuthInfo{URL: resp.URL, Team: resp.Team, User: resp.User, UserID: resp.UserID}
+	for _, s := range strings.Split(reply.header.Get("X-OAuth-Scopes"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			a.Scopes = append(a.Scopes, s)
+		}
+	}
+	return a, nil
+}
+
+func (c SlackClient) Channels() (ChannelList, error) {
+	var result ChannelList
+	cursor := ""
+	for {
+		values := url.Values{
+			"types":            {"public_channel,private_channel"},
+			"exclude_archived": {"true"},
+			"limit":            {channelsPageSize},
+		}
+		if cursor != "" {
+			values.Set("cursor", cursor)
+		}
+		var resp struct {
+			Channels []Conversation `json:"channels"`
+		}
+		reply, err := c.callSlack("conversations.list", values, &resp)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, resp.Channels...)
+		if reply.Metadata.NextCursor == "" {
+			return result, nil
+		}
+		cursor = reply.Metadata.NextCursor
+	}
+}
+
+func (c SlackClient) History(channel, oldest string, limit int) ([]slack.Msg, error) {
+	values := url.Values{
+		"channel": {channel},
+		"limit":   {strconv.Itoa(limit)},
+	}
+	if oldest != "" {
+		values.Set("oldest", oldest)
+	}
+	var resp struct {
+		Messages []slack.Msg `json:"messages"`
+	}
+	_, err := c.callSlack("conversations.history", values, &resp)
+	return resp.Messages, err
+}
+
+// Repost posts the text as the bot user without unfurling and markdown. The
+// text is already formatted for Slack, so it isn't escaped.
+func (c SlackClient) Repost(channel, text string) (string, error) {
+	values := url.Values{
+		"channel":      {channel},
+		"text":         {text},
+		"as_user":      {"true"},
+		"unfurl_links": {"false"},
+		"unfurl_media": {"false"},
+		"mrkdwn":       {"false"},
+	}
+	var resp struct {
+		Timestamp string `json:"ts"`
+	}
+	_, err := c.callSlack("chat.postMessage", values, &resp)
+	return resp.Timestamp, err
+}
+
+// Post posts the text as the bot user with markdown and without unfurling.
+func (c SlackClient) Post(channel, text string) (string, error) {
+	values := url.Values{
+		"channel":      {channel},
+		"text":         {text},
+		"as_user":      {"true"},
+		"unfurl_links": {"false"},
+		"unfurl_media": {"false"},
+		"mrkdwn":       {"true"},
+	}
+	var resp struct {
+		Timestamp string `json:"ts"`
+	}
+	_, err := c.callSlack("chat.postMessage", values, &resp)
+	return resp.Timestamp, err
+}
+
+func (c SlackClient) Update(channel, timestamp, text string) error {
+	values := url.Values{
+		"channel": {channel},
+		"ts":      {timestamp},
+		"text":    {text},
+		"as_user": {"true"},
+	}
+	_, err := c.callSlack("chat.update", values, nil)
+	return err
+}
+
+func (c SlackClient) Delete(channel, timestamp string) error {
+	_, err := c.callSlack("chat.delete", url.Values{"channel": {channel}, "ts": {timestamp}}, nil)
+	return err
+}
+
+func (c SlackClient) AddReaction(channel, timestamp, name string) error {
+	_, err := c.callSlack("reactions.add", url.Values{"channel": {channel}, "timestamp": {timestamp}, "name": {name}}, nil)
+	return err
+}
+
+func (c SlackClient) RemoveReaction(channel, timestamp, name string) error {
+	_, err := c.callSlack("reactions.remove", url.Values{"channel": {channel}, "timestamp": {timestamp}, "name": {name}}, nil)
+	return err
+}
+
+func (c SlackClient) OpenIM(user string) (string, error) {
+	var resp struct {
+		Channel struct {
+			ID string `json:"id"`
+		} `json:"channel"`
+	}
+	_, err := c.callSlack("im.open", url.Values{"user": {user}}, &resp)
+	return resp.Channel.ID, err
+}
+
+func (c SlackClient) SendDM(user, text string) error {
+	channel, err := c.OpenIM(user)
+	if err != nil {
+		return err
+	}
+	_, err = c.Post(channel, text)
+	return err
+}
+
+type apiResponse struct {
+	Ok       bool   `json:"ok"`
+	Error    string `json:"error"`
+	Metadata struct {
+		NextCursor string `json:"next_cursor"`
+	} `json:"response_metadata"`
+
+	header http.Header
+}
+
+// rateLimitError is returned when Slack answers 429 Too Many Requests, with
+// the delay it asks to wait before the next call.
+type rateLimitError struct {
+	Method     string
+	RetryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return e.Method + ": rate limited, retry after " + e.RetryAfter.String()
+}
+
+// apiError is an error code a Web API method replied with.
+type apiError struct {
+	Method string
+	Code   string
+}
+
+func (e *apiError) Error() string {
+	return e.Method + ": " + e.Code
+}
+
+// callSlack invokes a Web API method and decodes the reply into out. An
+// error code Slack replied with becomes apiError and 429 rateLimitError.
+func (c SlackClient) callSlack(method string, values url.Values, out interface{}) (*apiResponse, error) {
+	var reply *apiResponse
+	err := observeAPI(c.Workspace, method, func() (err error) {
+		reply, err = c.postSlack(method, values, out)
+		return err
+	})
+	return reply, err
+}
+
+func (c SlackClient) postSlack(method string, values url.Values, out interface{}) (*apiResponse, error) {
+	values.Set("token", c.Token)
+	req, err := http.NewRequest("POST", c.apiURL+method, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return nil, &rateLimitError{Method: method, RetryAfter: time.Duration(seconds) * time.Second}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(method + ": " + resp.Status)
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, err
+	}
+	body := &apiResponse{header: resp.Header}
+	if err := json.Unmarshal(raw, body); err != nil {
+		return nil, err
+	}
+	if !body.Ok {
+		return nil, &apiError{Method: method, Code: body.Error}
+	}
+	if out != nil {
+		if err := json.Unmarshal(raw, out); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+// permalink builds a link to a message from the workspace URL reported by
+// auth.test, e.g. https://team.slack.com/archives/C024BE91L/p1355517523000008,
+// and falls back to a link to the channel when the URL is unknown.
+func (b *Bot) permalink(channel, ts string) string {
+	if b.teamURL == "" {
+		return "<#" + channel + ">"
+	}
+	return strings.TrimSuffix(b.teamURL, "/") + "/archives/" + channel + "/p" + strings.Replace(ts, ".", "", 1)
+}
@@ -0,0 +1,448 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/nlopes/slack"
+)
+
+const fakeBotID = "UBOT"
+
+// fakeSlack is an in-memory workspace implementing Slacker. The bot posts
+// as fakeBotID, direct message channels are named "D" + user ID. Calls of
+// a Web API method fail with the errors given to fail before succeeding.
+type fakeSlack struct {
+	mu        sync.Mutex
+	teamURL   string
+	users     []slack.User
+	channels  ChannelList
+	history   map[string][]slack.Msg
+	reactions map[string][]string
+	errs      map[string][]error
+	calls     map[string]int
+	scopes    []string
+	clock     int
+	// markdown holds timestamps of posts with markdown.
+	markdown map[string]bool
+}
+
+func newFakeSlack() *fakeSlack {
+	return &fakeSlack{
+		teamURL:   "https://qa.slack.com/",
+		users:     []slack.User{{ID: fakeBotID, Name: "bot"}},
+		history:   make(map[string][]slack.Msg),
+		reactions: make(map[string][]string),
+		errs:      make(map[string][]error),
+		calls:     make(map[string]int),
+		markdown:  make(map[string]bool),
+	}
+}
+
+func (f *fakeSlack) addUser(id, name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.users = append(f.users, slack.User{ID: id, Name: name})
+}
+
+// addChannel adds a public channel the bot is a member of.
+func (f *fakeSlack) addChannel(id, name string) {
+	f.addConversation(Conversation{ID: id, Name: name, Member: true})
+}
+
+func (f *fakeSlack) addConversation(c Conversation) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.channels = append(f.channels, c)
+}
+
+// addMessage puts a message of the user into the channel history and
+// returns its timestamp.
+func (f *fakeSlack) addMessage(channel, user, text string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.post(channel, user, text)
+}
+
+func (f *fakeSlack) fail(method string, errs ...error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errs[method] = append(f.errs[method], errs...)
+}
+
+// posts returns texts the bot posted by channel.
+func (f *fakeSlack) posts() map[string][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	result := make(map[string][]string)
+	for channel, msgs := range f.history {
+		for _, m := range msgs {
+			if m.User == fakeBotID {
+				result[channel] = append(result[channel], m.Text)
+			}
+		}
+	}
+	return result
+}
+
+// call counts a call of the method and returns the next error set for it.
+// The caller holds the lock.
+// markdownPosts returns texts the bot posted with markdown by channel.
+func (f *fakeSlack) markdownPosts() map[string][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	result := make(map[string][]string)
+	for channel, msgs := range f.history {
+		for _, m := range msgs {
+			if f.markdown[m.Timestamp] {
+				result[channel] = append(result[channel], m.Text)
+			}
+		}
+	}
+	return result
+}
+
+func (f *fakeSlack) call(method string) error {
+	f.calls[method]++
+	if errs := f.errs[method]; len(errs) > 0 {
+		f.errs[method] = errs[1:]
+		return errs[0]
+	}
+	return nil
+}
+
+func (f *fakeSlack) post(channel, user, text string) string {
+	f.clock++
+	ts := fmt.Sprintf("1500000000.%06d", f.clock)
+	f.history[channel] = append(f.history[channel], slack.Msg{Channel: channel, User: user, Text: text, Timestamp: ts})
+	return ts
+}
+
+// find returns the index of the message in the channel history or -1.
+func (f *fakeSlack) find(channel, timestamp string) int {
+	for i, m := range f.history[channel] {
+		if m.Timestamp == timestamp {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *fakeSlack) GetUserInfo(id string) (*slack.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("users.info"); err != nil {
+		return nil, err
+	}
+	for _, u := range f.users {
+		if u.ID == id {
+			return &u, nil
+		}
+	}
+	return nil, &apiError{Method: "users.info", Code: "user_not_found"}
+}
+
+func (f *fakeSlack) GetUsersPage(cursor string) ([]slack.User, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("users.list"); err != nil {
+		return nil, "", err
+	}
+	return append([]slack.User(nil), f.users...), "", nil
+}
+
+func (f *fakeSlack) AuthTest() (*AuthInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("auth.test"); err != nil {
+		return nil, err
+	}
+	return &AuthInfo{URL: f.teamURL, Team: "qa", User: "bot", UserID: fakeBotID, Scopes: f.scopes}, nil
+}
+
+func (f *fakeSlack) Channels() (ChannelList, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("conversations.list"); err != nil {
+		return nil, err
+	}
+	var result ChannelList
+	for _, c := range f.channels {
+		if !c.Private || c.Member {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+func (f *fakeSlack) History(channel, oldest string, limit int) ([]slack.Msg, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("conversations.history"); err != nil {
+		return nil, err
+	}
+	var result []slack.Msg
+	msgs := f.history[channel]
+	for i := len(msgs) - 1; i >= 0 && len(result) < limit; i-- {
+		if msgs[i].Timestamp > oldest {
+			result = append(result, msgs[i])
+		}
+	}
+	return result, nil
+}
+
+func (f *fakeSlack) Repost(channel, text string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("chat.postMessage"); err != nil {
+		return "", err
+	}
+	return f.post(channel, fakeBotID, text), nil
+}
+
+func (f *fakeSlack) Post(channel, text string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("chat.postMessage"); err != nil {
+		return "", err
+	}
+	ts := f.post(channel, fakeBotID, text)
+	f.markdown[ts] = true
+	return ts, nil
+}
+
+func (f *fakeSlack) Update(channel, timestamp, text string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("chat.update"); err != nil {
+		return err
+	}
+	i := f.find(channel, timestamp)
+	if i < 0 {
+		return &apiError{Method: "chat.update", Code: "message_not_found"}
+	}
+	f.history[channel][i].Text = text
+	return nil
+}
+
+func (f *fakeSlack) Delete(channel, timestamp string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("chat.delete"); err != nil {
+		return err
+	}
+	i := f.find(channel, timestamp)
+	if i < 0 {
+		return &apiError{Method: "chat.delete", Code: "message_not_found"}
+	}
+	f.history[channel] = append(f.history[channel][:i], f.history[channel][i+1:]...)
+	return nil
+}
+
+func (f *fakeSlack) AddReaction(channel, timestamp, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("reactions.add"); err != nil {
+		return err
+	}
+	key := channel + "/" + timestamp
+	for _, r := range f.reactions[key] {
+		if r == name {
+			return &apiError{Method: "reactions.add", Code: "already_reacted"}
+		}
+	}
+	f.reactions[key] = append(f.reactions[key], name)
+	return nil
+}
+
+func (f *fakeSlack) RemoveReaction(channel, timestamp, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("reactions.remove"); err != nil {
+		return err
+	}
+	key := channel + "/" + timestamp
+	for i, r := range f.reactions[key] {
+		if r == name {
+			f.reactions[key] = append(f.reactions[key][:i], f.reactions[key][i+1:]...)
+			return nil
+		}
+	}
+	return &apiError{Method: "reactions.remove", Code: "no_reaction"}
+}
+
+func (f *fakeSlack) OpenIM(user string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return "D" + user, f.call("im.open")
+}
+
+func (f *fakeSlack) SendDM(user, text string) error {
+	channel, err := f.OpenIM(user)
+	if err != nil {
+		return err
+	}
+	_, err = f.Post(channel, text)
+	return err
+}
+
+func TestFakeSlack(t *testing.T) {
+	f := newFakeSlack()
+	first := f.addMessage("C1", "U1", "first")
+	ts, err := f.Repost("C1", "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Update("C1", ts, "edited"); err != nil {
+		t.Error("Can't update message: ", err)
+	}
+	if err := f.AddReaction("C1", first, "eyes"); err != nil {
+		t.Error("Can't add reaction: ", err)
+	}
+	if err := f.RemoveReaction("C1", first, "eyes"); err != nil {
+		t.Error("Can't remove reaction: ", err)
+	}
+	if err := f.RemoveReaction("C1", first, "eyes"); err == nil {
+		t.Error("Removing a missing reaction should fail")
+	}
+
+	msgs, _ := f.History("C1", "", 10)
+	if len(msgs) != 2 || msgs[0].Text != "edited" || msgs[1].Text != "first" {
+		t.Errorf("Actual history: %+v", msgs)
+	}
+	if msgs, _ := f.History("C1", first, 10); len(msgs) != 1 {
+		t.Errorf("History after %s should have 1 message, actual: %+v", first, msgs)
+	}
+
+	f.fail("chat.delete", errors.New("timeout"))
+	if err := f.Delete("C1", ts); err == nil {
+		t.Error("Delete should fail once")
+	}
+	if err := f.Delete("C1", ts); err != nil {
+		t.Error("Can't delete message: ", err)
+	}
+	if err := f.SendDM("U1", "hi"); err != nil {
+		t.Error("Can't send DM: ", err)
+	}
+	expected := map[string][]string{"DU1": {"hi"}}
+	if !reflect.DeepEqual(f.posts(), expected) {
+		t.Errorf("Actual posts: %v, expected: %v", f.posts(), expected)
+	}
+}
+
+func TestSlackerClient(t *testing.T) {
+	var mu sync.Mutex
+	var methods []string
+	var posted url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		methods = append(methods, r.URL.Path[1:])
+		mu.Unlock()
+		if r.FormValue("token") != "xoxb-"+t.Name() {
+			json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error": "invalid_auth"})
+			return
+		}
+		resp := map[string]interface{}{"ok": true}
+		switch r.URL.Path {
+		case "/auth.test":
+			w.Header().Set("X-OAuth-Scopes", "chat:write, users:read")
+			resp["url"] = "https://qa.slack.com/"
+			resp["user"] = "bot"
+		case "/conversations.list":
+			if r.FormValue("cursor") == "" {
+				resp["channels"] = []map[string]interface{}{{"id": "C1", "name": "general", "is_member": true}}
+				resp["response_metadata"] = map[string]string{"next_cursor": "page2"}
+			} else {
+				resp["channels"] = []map[string]interface{}{{"id": "G1", "name": "secret", "is_private": true, "is_member": true}}
+			}
+		case "/conversations.history":
+			resp["messages"] = []map[string]string{{"type": "message", "user": "U1", "text": r.FormValue("channel"), "ts": "2.0"}}
+		case "/chat.postMessage":
+			posted = r.PostForm
+			resp["ts"] = "3.0"
+		case "/im.open":
+			resp["channel"] = map[string]string{"id": "D1"}
+		case "/reactions.remove":
+			resp = map[string]interface{}{"ok": false, "error": "no_reaction"}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	var c Slacker = NewSlackClient("xoxb-"+t.Name(), server.URL+"/", nil)
+	auth, err := c.AuthTest()
+	if err != nil || auth.URL != "https://qa.slack.com/" || auth.User != "bot" || !reflect.DeepEqual(auth.Scopes, []string{"chat:write", "users:read"}) {
+		t.Errorf("Actual auth: %+v, %v", auth, err)
+	}
+	channels, err := c.Channels()
+	expected := ChannelList{{ID: "C1", Name: "general", Member: true}, {ID: "G1", Name: "secret", Private: true, Member: true}}
+	if err != nil || !reflect.DeepEqual(channels, expected) {
+		t.Errorf("Actual channels: %v, %v, expected: %v", channels, err, expected)
+	}
+	msgs, err := c.History("C1", "1.0", 10)
+	if err != nil || len(msgs) != 1 || msgs[0].Text != "C1" || msgs[0].Timestamp != "2.0" {
+		t.Errorf("Actual history: %+v, %v", msgs, err)
+	}
+	if ts, err := c.Repost("C1", "text"); err != nil || ts != "3.0" {
+		t.Errorf("Actual timestamp: %s, %v", ts, err)
+	}
+	for k, v := range map[string]string{"channel": "C1", "text": "text", "as_user": "true", "unfurl_links": "false", "unfurl_media": "false", "mrkdwn": "false"} {
+		if posted.Get(k) != v {
+			t.Errorf("Actual %s of the repost: %q, expected: %q", k, posted.Get(k), v)
+		}
+	}
+	if _, err := c.Post("C1", "*digest*"); err != nil || posted.Get("mrkdwn") != "true" {
+		t.Errorf("Actual markdown of the post: %q, %v", posted.Get("mrkdwn"), err)
+	}
+	if err := c.Update("C1", "3.0", "edited"); err != nil {
+		t.Error("Can't update message: ", err)
+	}
+	if err := c.AddReaction("C1", "3.0", "eyes"); err != nil {
+		t.Error("Can't add reaction: ", err)
+	}
+	if err, ok := c.RemoveReaction("C1", "3.0", "eyes").(*apiError); !ok || err.Code != "no_reaction" {
+		t.Errorf("Actual error: %v, expected: no_reaction", err)
+	}
+	if err := c.SendDM("U1", "hi"); err != nil {
+		t.Error("Can't send DM: ", err)
+	}
+	if err := c.Delete("C1", "3.0"); err != nil {
+		t.Error("Can't delete message: ", err)
+	}
+
+	sort.Strings(methods)
+	calls := []string{"auth.test", "chat.delete", "chat.postMessage", "chat.postMessage", "chat.postMessage", "chat.update",
+		"conversations.history", "conversations.list", "conversations.list", "im.open", "reactions.add", "reactions.remove"}
+	if !reflect.DeepEqual(methods, calls) {
+		t.Errorf("Actual methods: %v, expected: %v", methods, calls)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestSlackClientUsesOwnHTTPClient(t *testing.T) {
+	var calls []string
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls = append(calls, r.URL.String())
+		return nil, errors.New("offline")
+	})}
+	c := NewSlackClient("xoxb-"+t.Name(), "https://slack.example.com/api/", client)
+	if err := c.Delete("C1", "1.0"); err == nil {
+		t.Error("Delete should fail with the error of the HTTP client")
+	}
+	if !reflect.DeepEqual(calls, []string{"https://slack.example.com/api/chat.delete"}) {
+		t.Errorf("Actual calls: %v", calls)
+	}
+}
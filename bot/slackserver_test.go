@@ -0,0 +1,434 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+	"golang.org/x/net/websocket"
+)
+
+// fakeSlackServer serves the Web API methods the bot uses over a fakeSlack
+// workspace and an RTM websocket that sends scripted events. Messages the
+// bot posts are echoed back over RTM like Slack does.
+type fakeSlackServer struct {
+	*fakeSlack
+	server      *httptest.Server
+	token       string
+	events      chan interface{}
+	connections int32
+	// pageSize limits lists to check pagination of the bot.
+	pageSize int
+
+	// changed is closed and replaced after every API call the server
+	// handled, so tests wait for its effects in waitFor.
+	changedMu sync.Mutex
+	changed   chan struct{}
+}
+
+// newFakeSlackServer starts the server until the test ends. Clients of the
+// server are created with client.
+func newFakeSlackServer(t *testing.T) *fakeSlackServer {
+	s := &fakeSlackServer{
+		fakeSlack: newFakeSlack(),
+		token:     "xoxb-" + t.Name(),
+		events:    make(chan interface{}, 100),
+		pageSize:  2,
+		changed:   make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/rtm", websocket.Server{Handler: s.serveRTM})
+	mux.HandleFunc("/", s.serveAPI)
+	s.server = httptest.NewServer(mux)
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// client creates a client calling the server with the token, which is
+// accepted only if it is s.token.
+func (s *fakeSlackServer) client(token string) SlackClient {
+	rtmStarts.route(token, s.server.URL+"/api/")
+	return NewSlackClient(token, s.server.URL+"/api/", nil)
+}
+
+// rtmRouter is the HTTP client of the vendored Slack client in tests. The
+// client starts RTM at slack.SLACK_API, so the call is sent to the fake
+// server the token was created for instead.
+type rtmRouter struct {
+	mu   sync.Mutex
+	urls map[string]string
+}
+
+var (
+	rtmStarts     = &rtmRouter{urls: make(map[string]string)}
+	installRouter sync.Once
+)
+
+func (r *rtmRouter) route(token, apiURL string) {
+	installRouter.Do(func() {
+		slack.SetHTTPClient(r)
+	})
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.urls[token] = apiURL
+}
+
+func (r *rtmRouter) Do(req *http.Request) (*http.Response, error) {
+	if err := req.ParseForm(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	apiURL, ok := r.urls[req.PostForm.Get("token")]
+	r.mu.Unlock()
+	if !ok {
+		return nil, errors.New("No fake server for the token")
+	}
+	u, err := url.Parse(apiURL + strings.TrimPrefix(req.URL.String(), slack.SLACK_API))
+	if err != nil {
+		return nil, err
+	}
+	form := req.PostForm.Encode()
+	out, err := http.NewRequest(req.Method, u.String(), strings.NewReader(form))
+	if err != nil {
+		return nil, err
+	}
+	out.Header = req.Header
+	return http.DefaultClient.Do(out)
+}
+
+// send queues an RTM event for the connected client.
+func (s *fakeSlackServer) send(event interface{}) {
+	s.events <- event
+}
+
+// say posts a message of the user to the channel and sends it over RTM.
+func (s *fakeSlackServer) say(channel, user, text string) string {
+	ts := s.addMessage(channel, user, text)
+	s.send(map[string]string{"type": "message", "channel": channel, "user": user, "text": text, "ts": ts})
+	return ts
+}
+
+func (s *fakeSlackServer) serveRTM(ws *websocket.Conn) {
+	atomic.AddInt32(&s.connections, 1)
+	defer ws.Close()
+	if websocket.JSON.Send(ws, map[string]string{"type": "hello"}) != nil {
+		return
+	}
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			var msg struct {
+				ID   int    `json:"id"`
+				Type string `json:"type"`
+			}
+			if websocket.JSON.Receive(ws, &msg) != nil {
+				return
+			}
+			if msg.Type == "ping" {
+				s.send(map[string]interface{}{"type": "pong", "reply_to": msg.ID})
+			}
+		}
+	}()
+	for {
+		select {
+		case <-closed:
+			return
+		case ev := <-s.events:
+			if websocket.JSON.Send(ws, ev) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *fakeSlackServer) serveAPI(w http.ResponseWriter, r *http.Request) {
+	defer s.notify()
+	method := strings.TrimPrefix(r.URL.Path, "/api/")
+	if r.FormValue("token") != s.token {
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error": "invalid_auth"})
+		return
+	}
+	resp, err := s.handle(method, r)
+	if method == "auth.test" {
+		s.mu.Lock()
+		w.Header().Set("X-OAuth-Scopes", strings.Join(s.scopes, ","))
+		s.mu.Unlock()
+	}
+	switch err := err.(type) {
+	case nil:
+		resp["ok"] = true
+		json.NewEncoder(w).Encode(resp)
+	case *rateLimitError:
+		w.Header().Set("Retry-After", strconv.Itoa(int(err.RetryAfter.Seconds())))
+		w.WriteHeader(http.StatusTooManyRequests)
+	case *apiError:
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error": err.Code})
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// handle calls the fake workspace and builds the reply of the method.
+func (s *fakeSlackServer) handle(method string, r *http.Request) (map[string]interface{}, error) {
+	channel, ts, text := r.FormValue("channel"), r.FormValue("ts"), r.FormValue("text")
+	switch method {
+	case "rtm.start":
+		self, err := s.GetUserInfo(fakeBotID)
+		if err != nil {
+			return nil, err
+		}
+		url := "ws" + strings.TrimPrefix(s.server.URL, "http") + "/rtm"
+		return map[string]interface{}{"url": url, "self": self}, nil
+	case "auth.test":
+		auth, err := s.AuthTest()
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"url": auth.URL, "team": auth.Team, "user": auth.User, "user_id": auth.UserID}, nil
+	case "users.list":
+		users, _, err := s.GetUsersPage(r.FormValue("cursor"))
+		return map[string]interface{}{"members": users}, err
+	case "users.info":
+		user, err := s.GetUserInfo(r.FormValue("user"))
+		return map[string]interface{}{"user": user}, err
+	case "conversations.list":
+		channels, err := s.Channels()
+		from, _ := strconv.Atoi(r.FormValue("cursor"))
+		limit, _ := strconv.Atoi(r.FormValue("limit"))
+		if limit <= 0 || limit > s.pageSize {
+			limit = s.pageSize
+		}
+		next := ""
+		if from+limit < len(channels) {
+			channels, next = channels[from:from+limit], strconv.Itoa(from+limit)
+		} else if from < len(channels) {
+			channels = channels[from:]
+		} else {
+			channels = nil
+		}
+		return map[string]interface{}{"channels": channels, "response_metadata": map[string]string{"next_cursor": next}}, err
+	case "conversations.history":
+		limit, _ := strconv.Atoi(r.FormValue("limit"))
+		msgs, err := s.History(channel, r.FormValue("oldest"), limit)
+		return map[string]interface{}{"messages": msgs}, err
+	case "chat.postMessage":
+		ts, err := s.Repost(channel, text)
+		if err == nil {
+			s.send(map[string]string{"type": "message", "channel": channel, "user": fakeBotID, "text": text, "ts": ts})
+		}
+		return map[string]interface{}{"channel": channel, "ts": ts}, err
+	case "chat.update":
+		return map[string]interface{}{}, s.Update(channel, ts, text)
+	case "chat.delete":
+		return map[string]interface{}{}, s.Delete(channel, ts)
+	case "reactions.add":
+		return map[string]interface{}{}, s.AddReaction(channel, r.FormValue("timestamp"), r.FormValue("name"))
+	case "reactions.remove":
+		return map[string]interface{}{}, s.RemoveReaction(channel, r.FormValue("timestamp"), r.FormValue("name"))
+	case "im.open":
+		id, err := s.OpenIM(r.FormValue("user"))
+		return map[string]interface{}{"channel": map[string]string{"id": id}}, err
+	}
+	return nil, &apiError{Method: method, Code: "unknown_method"}
+}
+
+// runTestBot connects a bot with the config to the fake server the same
+// way run does and returns a function stopping it.
+func runTestBot(t *testing.T, s *fakeSlackServer, cfg Config) (*Bot, func() error) {
+	client := s.client(s.token)
+	cfg.BotName = "bot"
+	b, err := NewBot(cfg, Deps{Slack: client, Store: openTestDB(t)})
+	if err != nil {
+		t.Fatal("Can't create bot: ", err)
+	}
+	t.Cleanup(func() { closeTestDB(b.store) })
+	if err := b.Init(); err != nil {
+		t.Fatal("Can't init bot: ", err)
+	}
+
+	rtm := client.Slack.NewRTM()
+	go rtm.ManageConnection()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Run(ctx, rtm.IncomingEvents)
+	}()
+	var result error
+	stopped := false
+	return b, func() error {
+		if !stopped {
+			stopped = true
+			cancel()
+			result = <-done
+			Disconnect(rtm, b.log)
+		}
+		return result
+	}
+}
+
+func (s *fakeSlackServer) notify() {
+	s.changedMu.Lock()
+	defer s.changedMu.Unlock()
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// waitFor blocks until the condition on the workspace holds, checking it
+// after every API call the server handles.
+func (s *fakeSlackServer) waitFor(cond func() bool) {
+	for {
+		s.changedMu.Lock()
+		changed := s.changed
+		s.changedMu.Unlock()
+		if cond() {
+			return
+		}
+		<-changed
+	}
+}
+
+func newTestWorkspace(t *testing.T) *fakeSlackServer {
+	s := newFakeSlackServer(t)
+	s.addUser("U1", "vasya")
+	s.addUser("U2", "petya")
+	s.addChannel("C1", "general")
+	s.addChannel("C2", "jobs")
+	return s
+}
+
+func TestEndToEndRepostAndCleanup(t *testing.T) {
+	s := newTestWorkspace(t)
+	rt := &Route{Name: "default", From: []string{"general"}, To: []string{"jobs"}, Format: "{{.Text}} from @{{.Author}} in #{{.Channel}}"}
+	b, stop := runTestBot(t, s, Config{Routes: []*Route{rt}})
+	defer stop()
+
+	text := "Вакансия тестировщика <@U2> https://hh.ru/vacancy/" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	s.say("C1", "U1", text)
+	s.waitFor(func() bool { return len(s.posts()["C2"]) == 1 })
+	if atomic.LoadInt32(&b.connected) != 1 {
+		t.Error("Bot should be connected after a message came over RTM")
+	}
+	if post := s.posts()["C2"][0]; !strings.Contains(post, "@petya") || !strings.HasSuffix(post, "from @vasya in #general") {
+		t.Errorf("Repost should have names of users: %s", post)
+	}
+
+	flood := s.say("C2", "U2", "flood")
+	s.waitFor(func() bool {
+		msgs, _ := s.History("C2", "", 10)
+		for _, m := range msgs {
+			if m.Timestamp == flood {
+				return false
+			}
+		}
+		return true
+	})
+
+	s.say("DU1", "U1", "subscribe python")
+	s.waitFor(func() bool { return len(s.posts()["DU1"]) == 1 })
+
+	if err := stop(); err != nil {
+		t.Error("Bot stopped with error: ", err)
+	}
+	if n := len(s.posts()["C2"]); n != 1 {
+		t.Errorf("Own reposts of the bot shouldn't be deleted, reposts left: %d", n)
+	}
+	if p, err := listPostings(time.Time{}, time.Now().Add(time.Second), b.store); err != nil || len(p) != 1 || !strings.HasPrefix(p[0].Link, "https://qa.slack.com/archives/C2/p") {
+		t.Errorf("Actual postings: %+v, %v", p, err)
+	}
+}
+
+func TestEndToEndPrivateChannels(t *testing.T) {
+	s := newTestWorkspace(t)
+	s.addConversation(Conversation{ID: "C3", Name: "random"})
+	s.addConversation(Conversation{ID: "G4", Name: "hiring", Private: true, Member: true})
+	s.addConversation(Conversation{ID: "G5", Name: "secret", Private: true})
+	rt := &Route{Name: "private", From: []string{"#hiring"}, To: []string{"C2"}}
+	_, stop := runTestBot(t, s, Config{Routes: []*Route{rt}})
+	defer stop()
+
+	s.say("G4", "U1", "Вакансия тестировщика https://hh.ru/vacancy/"+strconv.FormatInt(time.Now().UnixNano(), 10))
+	s.waitFor(func() bool { return len(s.posts()["C2"]) == 1 })
+
+	for _, v := range []struct {
+		channel, err string
+	}{
+		{"random", "Bot isn't a member of channel random"},
+		{"secret", "Can't find channel secret"},
+	} {
+		rt := &Route{Name: "broken", From: []string{v.channel}, To: []string{"jobs"}}
+		b, err := NewBot(Config{Routes: []*Route{rt}}, Deps{Slack: s.client(s.token), Store: openTestDB(t)})
+		if err != nil {
+			t.Fatal("Can't create bot: ", err)
+		}
+		if err := b.Init(); err == nil || !strings.Contains(err.Error(), v.err) {
+			t.Errorf("For channel: %s, actual error: %v, expected: %s", v.channel, err, v.err)
+		}
+		closeTestDB(b.store)
+	}
+}
+
+func TestEndToEndRetriesRateLimitedRepost(t *testing.T) {
+	s := newTestWorkspace(t)
+	s.fail("chat.postMessage", &rateLimitError{Method: "chat.postMessage"})
+	rt := &Route{Name: "default", From: []string{"general"}, To: []string{"jobs"}}
+	b, stop := runTestBot(t, s, Config{Routes: []*Route{rt}})
+	defer stop()
+
+	text := "Вакансия тестировщика https://hh.ru/vacancy/" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	s.say("C1", "U1", text)
+	s.waitFor(func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.calls["chat.postMessage"] > 0
+	})
+	// Stopping waits for the message being processed, so the failed attempt
+	// is in the outbox.
+	if err := stop(); err != nil {
+		t.Error("Bot stopped with error: ", err)
+	}
+	jobs, _ := claimJobs(time.Now().Add(time.Hour), b.store)
+	if len(jobs) != 1 || jobs[0].Attempts != 1 {
+		t.Fatalf("Actual jobs: %+v", jobs)
+	}
+	b.deliver(jobs)
+	if n := len(s.posts()["C2"]); n != 1 {
+		t.Errorf("Actual number of reposts: %d, expected: 1", n)
+	}
+}
+
+func TestEndToEndInvalidToken(t *testing.T) {
+	s := newTestWorkspace(t)
+	client := s.client("xoxb-wrong-" + t.Name())
+	b, err := NewBot(Config{}, Deps{Slack: client, Store: openTestDB(t)})
+	if err != nil {
+		t.Fatal("Can't create bot: ", err)
+	}
+	defer closeTestDB(b.store)
+	if err := b.Init(); err == nil {
+		t.Error("Init should fail with invalid token")
+	}
+
+	rtm := client.Slack.NewRTM()
+	go rtm.ManageConnection()
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Run(context.Background(), rtm.IncomingEvents)
+	}()
+	if err := <-done; err == nil || err.Error() != invalidCredentials {
+		t.Errorf("Actual error: %v, expected: %s", err, invalidCredentials)
+	}
+	if n := atomic.LoadInt32(&s.connections); n != 0 {
+		t.Errorf("Actual RTM connections: %d, expected: 0", n)
+	}
+}
@@ -0,0 +1,308 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	eventsBucket = "EVENTS"
+
+	eventSeen      = "seen"
+	eventJob       = "job"
+	eventReposted  = "reposted"
+	eventDuplicate = "duplicate"
+	eventDeleted   = "deleted"
+
+	// DefaultStatsPeriod is the period stats report on when none is given.
+	DefaultStatsPeriod = "7d"
+	statsTopSize       = 5
+
+	// eventsFlush is how often recorded events are written to the store.
+	eventsFlush = time.Second
+	// eventsRetention is how long events are kept, the longest period
+	// stats can report on.
+	eventsRetention = 90 * 24 * time.Hour
+)
+
+// event is a single step of message processing recorded for stats.
+type event struct {
+	Kind    string    `json:"kind"`
+	Channel string    `json:"channel"`
+	User    string    `json:"user"`
+	Time    time.Time `json:"time"`
+}
+
+type statsReport struct {
+	From, To   time.Time
+	Counts     map[string]int
+	Companies  []rank
+	Authors    []rank
+	ByHour     [24]int
+	JobsByHour [24]int
+}
+
+type rank struct {
+	Name  string
+	Count int
+}
+
+// eventKey orders events by time; the sequence keeps keys of events
+// recorded within the same nanosecond unique.
+func eventKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+// recordEvent keeps the event until flushEvents writes it to the store, so
+// messages don't cost a write transaction each.
+func (b *Bot) recordEvent(kind, channel, user string) {
+	b.eventsMu.Lock()
+	b.events = append(b.events, event{Kind: kind, Channel: channel, User: user, Time: b.now()})
+	b.eventsMu.Unlock()
+}
+
+// flushEvents writes recorded events in one transaction and removes events
+// older than eventsRetention.
+func (b *Bot) flushEvents() {
+	b.eventsMu.Lock()
+	events := b.events
+	b.events = nil
+	b.eventsMu.Unlock()
+	if len(events) == 0 {
+		return
+	}
+	err := b.store.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(eventsBucket))
+		for _, e := range events {
+			seq, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			value, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(eventKey(e.Time, seq), value); err != nil {
+				return err
+			}
+		}
+		var expired [][]byte
+		end := eventKey(b.now().Add(-eventsRetention), 0)
+		cur := bucket.Cursor()
+		for k, _ := cur.First(); k != nil && bytes.Compare(k, end) < 0; k, _ = cur.Next() {
+			expired = append(expired, k)
+		}
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.log.Error("Can't record events", "events", len(events), "error", err)
+	}
+}
+
+// RunEvents writes recorded events every eventsFlush until stop is closed.
+func (b *Bot) RunEvents(stop <-chan struct{}) {
+	ticker := time.NewTicker(eventsFlush)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			b.flushEvents()
+		}
+	}
+}
+
+func listEvents(from, to time.Time, db *bolt.DB) ([]event, error) {
+	var result []event
+	end := eventKey(to, 0)
+	err := db.View(func(tx *bolt.Tx) error {
+		cur := tx.Bucket([]byte(eventsBucket)).Cursor()
+		for k, v := cur.Seek(eventKey(from, 0)); k != nil && bytes.Compare(k, end) < 0; k, v = cur.Next() {
+			var e event
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			result = append(result, e)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// ParsePeriod accepts Go durations and a number of days like "30d".
+func ParsePeriod(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days <= 0 {
+			return 0, errors.New("Wrong period: " + s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, errors.New("Wrong period: " + s)
+	}
+	return d, nil
+}
+
+func collectStats(from, to time.Time, db *bolt.DB) (*statsReport, error) {
+	events, err := listEvents(from, to, db)
+	if err != nil {
+		return nil, err
+	}
+	postings, err := listPostings(from, to, db)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &statsReport{From: from, To: to, Counts: make(map[string]int)}
+	for _, e := range events {
+		report.Counts[e.Kind]++
+		if e.Kind == eventSeen {
+			report.ByHour[e.Time.Local().Hour()]++
+		}
+		if e.Kind == eventJob {
+			report.JobsByHour[e.Time.Local().Hour()]++
+		}
+	}
+	companies := make(map[string]int)
+	authors := make(map[string]int)
+	for _, p := range postings {
+		if p.Fields.Company != "" {
+			companies[p.Fields.Company]++
+		}
+		author := p.Author
+		if author == "" {
+			author = p.User
+		}
+		if author != "" {
+			authors[author]++
+		}
+	}
+	report.Companies = top(companies, statsTopSize)
+	report.Authors = top(authors, statsTopSize)
+	return report, nil
+}
+
+func top(counts map[string]int, n int) []rank {
+	var result []rank
+	for name, count := range counts {
+		result = append(result, rank{name, count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Name < result[j].Name
+	})
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+func (r *statsReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Stats from %s to %s\n", r.From.Format("02.01.2006 15:04"), r.To.Format("02.01.2006 15:04"))
+	fmt.Fprintf(&b, "Messages seen: %d\n", r.Counts[eventSeen])
+	fmt.Fprintf(&b, "Job postings: %d\n", r.Counts[eventJob])
+	fmt.Fprintf(&b, "Reposts: %d\n", r.Counts[eventReposted])
+	fmt.Fprintf(&b, "Duplicates blocked: %d\n", r.Counts[eventDuplicate])
+	fmt.Fprintf(&b, "Deletions in target channels: %d\n", r.Counts[eventDeleted])
+	fmt.Fprintf(&b, "Top companies: %s\n", formatRanks(r.Companies))
+	fmt.Fprintf(&b, "Top authors: %s\n", formatRanks(r.Authors))
+	b.WriteString("Messages / job postings by hour:\n")
+	for hour := range r.ByHour {
+		if r.ByHour[hour] == 0 && r.JobsByHour[hour] == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "%02d:00 %d / %d\n", hour, r.ByHour[hour], r.JobsByHour[hour])
+	}
+	return b.String()
+}
+
+func formatRanks(ranks []rank) string {
+	if len(ranks) == 0 {
+		return "-"
+	}
+	var parts []string
+	for _, r := range ranks {
+		parts = append(parts, fmt.Sprintf("%s (%d)", r.Name, r.Count))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Stats reports on messages processed and postings made from one time to
+// another, like the stats command.
+func Stats(from, to time.Time, db *bolt.DB) (string, error) {
+	report, err := collectStats(from, to, db)
+	if err != nil {
+		return "", err
+	}
+	return report.String(), nil
+}
+
+// slashCommandHandler answers a Slack slash command with an ephemeral reply
+// to its text.
+func (b *Bot) slashCommandHandler(verificationToken string, answer func(text string) string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// Without a token anyone who can reach the server could read the
+		// stats, so commands are refused; Check warns about it on start.
+		if verificationToken == "" {
+			http.Error(w, "Slash commands need a verification token", http.StatusForbidden)
+			return
+		}
+		if r.PostFormValue("token") != verificationToken {
+			http.Error(w, "Wrong token", http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"response_type": "ephemeral",
+			"text":          answer(strings.TrimSpace(r.PostFormValue("text"))),
+		})
+	}
+}
+
+// statsReply answers a slash command like "/jobstats 30d" with a stats
+// report.
+func (b *Bot) statsReply(text string) string {
+	if text == "" {
+		text = DefaultStatsPeriod
+	}
+	d, err := ParsePeriod(text)
+	if err != nil {
+		return "Can't get stats: " + err.Error()
+	}
+	b.flushEvents()
+	now := b.now()
+	report, err := Stats(now.Add(-d), now, b.store)
+	if err != nil {
+		return "Can't get stats: " + err.Error()
+	}
+	return "```" + report + "```"
+}
@@ -0,0 +1,147 @@
+package bot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+func TestParsePeriod(t *testing.T) {
+	cases := []struct {
+		in  string
+		res time.Duration
+	}{
+		{"7d", 7 * 24 * time.Hour},
+		{"36h", 36 * time.Hour},
+		{"0d", 0},
+		{"-1h", 0},
+		{"week", 0},
+	}
+
+	for _, v := range cases {
+		result, err := ParsePeriod(v.in)
+		if result != v.res || (v.res == 0) != (err != nil) {
+			t.Errorf("For string: %s, actual result: %v, %v, expected: %v", v.in, result, err, v.res)
+		}
+	}
+}
+
+func TestCollectStats(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+
+	fake := newFakeSlack()
+	client := newTestBot(t, fake, db, Config{Routes: []*Route{testRoute("C1", "C2")}})
+	client.users.Set("U1", "vasya")
+	for _, ev := range []*slack.MessageEvent{
+		{Msg: slack.Msg{Channel: "C1", User: "U1", Text: "hello"}},
+		{Msg: slack.Msg{Channel: "C1", User: "U1", Text: "Вакансия в EPAM https://careers.epam.com/job/1"}},
+		{Msg: slack.Msg{Channel: "C1", User: "U2", Text: "Вакансия в EPAM https://careers.epam.com/job/1"}},
+		{Msg: slack.Msg{Channel: "C1", User: "U2", Text: "Job: send CV to hr@kaspersky.com or vasya@gmail.com"}},
+		{Msg: slack.Msg{Channel: "C1", User: "U3", SubType: "channel_join", Text: "<@U3> has joined the channel"}},
+		{Msg: slack.Msg{Channel: "C1", SubType: "message_deleted", DeletedTimestamp: "1.1"}},
+	} {
+		client.RepostMessage(ev)
+	}
+	ts := fake.addMessage("C2", "U3", "flood")
+	client.DeleteMessage(&slack.MessageEvent{Msg: slack.Msg{Channel: "C2", User: "U3", Text: "flood", Timestamp: ts}})
+
+	now := time.Now()
+	if report, _ := collectStats(now.Add(-time.Hour), now.Add(time.Second), db); len(report.Counts) != 0 {
+		t.Errorf("Events are written before a flush: %v", report.Counts)
+	}
+	client.flushEvents()
+	report, err := collectStats(now.Add(-time.Hour), now.Add(time.Second), db)
+	if err != nil {
+		t.Fatal("Can't collect stats: ", err)
+	}
+	expected := map[string]int{eventSeen: 4, eventJob: 3, eventReposted: 2, eventDuplicate: 1, eventDeleted: 1}
+	for kind, count := range expected {
+		if report.Counts[kind] != count {
+			t.Errorf("For event: %s, actual count: %d, expected: %d", kind, report.Counts[kind], count)
+		}
+	}
+	if s := formatRanks(report.Companies); s != "epam.com (1), kaspersky.com (1)" {
+		t.Errorf("Actual companies: %s", s)
+	}
+	if s := formatRanks(report.Authors); s != "U2 (1), vasya (1)" {
+		t.Errorf("Actual authors: %s", s)
+	}
+	if n := report.ByHour[now.Hour()]; n != 4 {
+		t.Errorf("Actual messages in current hour: %d, expected: 4", n)
+	}
+
+	report, _ = collectStats(now.Add(-2*time.Hour), now.Add(-time.Hour), db)
+	if len(report.Counts) != 0 || len(report.Authors) != 0 {
+		t.Errorf("Stats out of period should be empty: %+v", report)
+	}
+}
+
+func TestSlashCommandHandler(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+	b := newTestBot(t, newFakeSlack(), db, Config{})
+	handler := b.slashCommandHandler("secret", b.statsReply)
+
+	cases := []struct {
+		form   url.Values
+		status int
+		text   string
+	}{
+		{url.Values{"token": {"wrong"}}, http.StatusUnauthorized, ""},
+		{url.Values{"token": {"secret"}}, http.StatusOK, "Messages seen: 0"},
+		{url.Values{"token": {"secret"}, "text": {"30d"}}, http.StatusOK, "Job postings: 0"},
+		{url.Values{"token": {"secret"}, "text": {"month"}}, http.StatusOK, "Can't get stats: Wrong period: month"},
+	}
+
+	for _, v := range cases {
+		req := httptest.NewRequest("POST", "/slack/command", strings.NewReader(v.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != v.status {
+			t.Errorf("For form: %v, actual status: %d, expected: %d", v.form, w.Code, v.status)
+			continue
+		}
+		if v.status != http.StatusOK {
+			continue
+		}
+		var resp map[string]string
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp["response_type"] != "ephemeral" || !strings.Contains(resp["text"], v.text) {
+			t.Errorf("For form: %v, actual response: %v, expected text: %s", v.form, resp, v.text)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	b.slashCommandHandler("", b.statsReply)(w, httptest.NewRequest("POST", "/slack/command", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Without verification token, actual status: %d", w.Code)
+	}
+}
+
+func TestEventsRetention(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+	b := newTestBot(t, newFakeSlack(), db, Config{})
+	now := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return now }
+
+	b.recordEvent(eventSeen, "C1", "U1")
+	b.recordEvent(eventJob, "C1", "U1")
+	b.flushEvents()
+	now = now.Add(eventsRetention + time.Hour)
+	b.recordEvent(eventSeen, "C1", "U2")
+	b.flushEvents()
+
+	events, err := listEvents(now.Add(-2*eventsRetention), now.Add(time.Hour), db)
+	if err != nil || len(events) != 1 || events[0].User != "U2" {
+		t.Errorf("Actual events: %+v, %v", events, err)
+	}
+}
@@ -0,0 +1,198 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	bucket = "QA-SLACK"
+	// sourcesBucket maps channel/ts of reposted messages to the keys of
+	// their postings.
+	sourcesBucket = "POSTING_SOURCES"
+)
+
+// posting is the record stored for every reposted message, keyed by text.
+type posting struct {
+	Text      string    `json:"text"`
+	Channel   string    `json:"channel"`
+	User      string    `json:"user"`
+	Author    string    `json:"author,omitempty"`
+	Timestamp string    `json:"ts"`
+	Tags      []string  `json:"tags,omitempty"`
+	Fields    fields    `json:"fields"`
+	Link      string    `json:"link,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+func createBuckets(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{bucket, subscriptionsBucket, alertsBucket, eventsBucket, outboxBucket, deliveriesBucket} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		// Indexes appeared later, build them for postings saved before.
+		for _, index := range []struct {
+			name  string
+			build func(tx *bolt.Tx) error
+		}{{searchBucket, indexPostings}, {sourcesBucket, indexSources}} {
+			if tx.Bucket([]byte(index.name)) != nil {
+				continue
+			}
+			if _, err := tx.CreateBucket([]byte(index.name)); err != nil {
+				return err
+			}
+			if err := index.build(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func alreadyPosted(text string, db *bolt.DB) bool {
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(bucket))
+		bytes := bucket.Get([]byte(text))
+		if bytes != nil {
+			return errors.New("")
+		}
+		return nil
+	})
+	return err != nil
+}
+
+// claimPosting saves the posting unless the text is already there, in one
+// transaction, and reports whether it did. Bots sharing a DB claim a text
+// before reposting it, so only one of them reposts it.
+func claimPosting(text string, p posting, db *bolt.DB) (bool, error) {
+	var claimed bool
+	err := db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(bucket)).Get([]byte(text)) != nil {
+			return nil
+		}
+		claimed = true
+		return putPosting(tx, text, p)
+	})
+	return claimed && err == nil, err
+}
+
+// releasePosting removes the claim of the posting that was never reposted,
+// unless the text was claimed again from another message since.
+func releasePosting(p posting, db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		postings := tx.Bucket([]byte(bucket))
+		var claim posting
+		if json.Unmarshal(postings.Get([]byte(p.Text)), &claim) != nil {
+			return nil
+		}
+		if claim.Link != "" || claim.Channel != p.Channel || claim.Timestamp != p.Timestamp {
+			return nil
+		}
+		if err := unindexPosting(tx, p.Text, claim); err != nil {
+			return err
+		}
+		if err := tx.Bucket([]byte(sourcesBucket)).Delete(sourceKey(claim.Channel, claim.Timestamp)); err != nil {
+			return err
+		}
+		return postings.Delete([]byte(p.Text))
+	})
+}
+
+func savePosted(text string, p posting, db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return putPosting(tx, text, p)
+	})
+}
+
+// putPosting saves the posting under the key and keeps the search index
+// and the index of sources up to date with it.
+func putPosting(tx *bolt.Tx, key string, p posting) error {
+	postings := tx.Bucket([]byte(bucket))
+	sources := tx.Bucket([]byte(sourcesBucket))
+	var prev posting
+	if json.Unmarshal(postings.Get([]byte(key)), &prev) == nil {
+		if err := unindexPosting(tx, key, prev); err != nil {
+			return err
+		}
+		if err := sources.Delete(sourceKey(prev.Channel, prev.Timestamp)); err != nil {
+			return err
+		}
+	}
+	value, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if err := postings.Put([]byte(key), value); err != nil {
+		return err
+	}
+	if err := indexPosting(tx, key, p); err != nil {
+		return err
+	}
+	if p.Channel == "" || p.Timestamp == "" {
+		return nil
+	}
+	return sources.Put(sourceKey(p.Channel, p.Timestamp), []byte(key))
+}
+
+func sourceKey(channel, ts string) []byte {
+	return []byte(channel + "/" + ts)
+}
+
+// indexSources builds the index of sources for postings saved before it
+// appeared.
+func indexSources(tx *bolt.Tx) error {
+	sources := tx.Bucket([]byte(sourcesBucket))
+	return tx.Bucket([]byte(bucket)).ForEach(func(k, v []byte) error {
+		var p posting
+		if json.Unmarshal(v, &p) != nil || p.Channel == "" || p.Timestamp == "" {
+			return nil
+		}
+		return sources.Put(sourceKey(p.Channel, p.Timestamp), k)
+	})
+}
+
+// listPostings returns postings reposted within [from, to) in the order
+// they were made. Records from older versions hold plain text and are skipped.
+func listPostings(from, to time.Time, db *bolt.DB) ([]posting, error) {
+	var result []posting
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bucket)).ForEach(func(k, v []byte) error {
+			var p posting
+			if json.Unmarshal(v, &p) != nil {
+				return nil
+			}
+			if !p.Time.Before(from) && p.Time.Before(to) {
+				result = append(result, p)
+			}
+			return nil
+		})
+	})
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Time.Before(result[j].Time)
+	})
+	return result, err
+}
+
+// findPosting returns the posting reposted from the message, or nil.
+func findPosting(channel, ts string, db *bolt.DB) (*posting, error) {
+	var result *posting
+	err := db.View(func(tx *bolt.Tx) error {
+		key := tx.Bucket([]byte(sourcesBucket)).Get(sourceKey(channel, ts))
+		if key == nil {
+			return nil
+		}
+		var p posting
+		if err := json.Unmarshal(tx.Bucket([]byte(bucket)).Get(key), &p); err != nil {
+			return err
+		}
+		result = &p
+		return nil
+	})
+	return result, err
+}
@@ -0,0 +1,351 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/nlopes/slack"
+)
+
+const (
+	subscriptionsBucket = "SUBSCRIPTIONS"
+	alertsBucket        = "ALERTS"
+	notDirectMessage    = "Not direct message"
+	previewLength       = 200
+
+	commandHelp = "Commands:\n" +
+		"`subscribe remote automation python tag:mobile location:berlin` - get a DM about every vacancy matching all filters\n" +
+		"`list` - show your subscriptions\n" +
+		"`unsubscribe 3` or `unsubscribe all` - remove subscriptions\n" +
+		"`search yandex selenium` - find reposted vacancies with all of the words"
+)
+
+// subscription is a set of filters a user wants to be alerted about. Every
+// filter has to match a posting for the alert to be sent.
+type subscription struct {
+	ID         uint64   `json:"id"`
+	User       string   `json:"user"`
+	Keywords   []string `json:"keywords,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	Locations  []string `json:"locations,omitempty"`
+	Remote     bool     `json:"remote,omitempty"`
+	Relocation bool     `json:"relocation,omitempty"`
+}
+
+func parseSubscription(user string, args []string) (subscription, error) {
+	s := subscription{User: user}
+	for _, arg := range args {
+		arg = strings.ToLower(arg)
+		switch {
+		case arg == "remote":
+			s.Remote = true
+		case arg == "relocation":
+			s.Relocation = true
+		case strings.HasPrefix(arg, "tag:"):
+			s.Tags = append(s.Tags, strings.TrimPrefix(arg, "tag:"))
+		case strings.HasPrefix(arg, "location:"):
+			location := strings.TrimPrefix(arg, "location:")
+			if names := matchTerms(location, locationTerms); len(names) > 0 {
+				location = strings.ToLower(names[0])
+			}
+			s.Locations = append(s.Locations, location)
+		case arg != "":
+			s.Keywords = append(s.Keywords, arg)
+		}
+	}
+	if len(s.Keywords)+len(s.Tags)+len(s.Locations) == 0 && !s.Remote && !s.Relocation {
+		return s, errors.New("Specify at least one filter")
+	}
+	return s, nil
+}
+
+func (s subscription) String() string {
+	var filters []string
+	if s.Remote {
+		filters = append(filters, "remote")
+	}
+	if s.Relocation {
+		filters = append(filters, "relocation")
+	}
+	filters = append(filters, s.Keywords...)
+	for _, tag := range s.Tags {
+		filters = append(filters, "tag:"+tag)
+	}
+	for _, location := range s.Locations {
+		filters = append(filters, "location:"+location)
+	}
+	return "#" + strconv.FormatUint(s.ID, 10) + ": " + strings.Join(filters, " ")
+}
+
+// matches checks the filters against the posting. Keywords and locations
+// are matched as words in any form, so "go" doesn't match "Google".
+func (s subscription) matches(p posting) bool {
+	terms := make(map[string]bool)
+	for _, term := range searchTerms(plainText(p.Text)) {
+		terms[term] = true
+	}
+	if s.Remote && !p.Fields.Remote {
+		return false
+	}
+	if s.Relocation && !p.Fields.Relocation {
+		return false
+	}
+	for _, tag := range s.Tags {
+		if !containsFold(p.Tags, tag) {
+			return false
+		}
+	}
+	for _, location := range s.Locations {
+		if !containsFold(p.Fields.Locations, location) && !hasTerms(terms, location) {
+			return false
+		}
+	}
+	for _, kw := range s.Keywords {
+		if !hasTerms(terms, kw) && !containsFold(p.Tags, kw) && !containsFold(p.Fields.Stack, kw) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasTerms reports whether terms have every search term of the words.
+func hasTerms(terms map[string]bool, words string) bool {
+	wanted := searchTerms(words)
+	for _, term := range wanted {
+		if !terms[term] {
+			return false
+		}
+	}
+	return len(wanted) > 0
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleCommand answers subscription and search commands sent to the bot
+// in a DM.
+func (b *Bot) HandleCommand(ev *slack.MessageEvent) error {
+	if !strings.HasPrefix(ev.Channel, "D") || ev.SubType != "" {
+		return errors.New(notDirectMessage)
+	}
+	if self := b.botUserID(); self == "" || ev.User == self {
+		return errors.New(wrongUserID)
+	}
+
+	args := strings.Fields(ev.Text)
+	reply := commandHelp
+	if len(args) > 0 {
+		switch strings.ToLower(args[0]) {
+		case "subscribe":
+			s, err := parseSubscription(ev.User, args[1:])
+			if err == nil {
+				s, err = saveSubscription(s, b.store)
+			}
+			reply = "Subscribed " + s.String()
+			if err != nil {
+				reply = "Can't subscribe: " + err.Error()
+			}
+		case "list":
+			list, err := listSubscriptions(ev.User, b.store)
+			reply = "You have no subscriptions"
+			if len(list) > 0 {
+				var lines []string
+				for _, s := range list {
+					lines = append(lines, s.String())
+				}
+				reply = strings.Join(lines, "\n")
+			}
+			if err != nil {
+				reply = "Can't list subscriptions: " + err.Error()
+			}
+		case "unsubscribe":
+			reply = unsubscribe(ev.User, args[1:], b.store)
+		case "search":
+			reply = b.searchReply(strings.Join(args[1:], " "))
+		}
+	}
+	_, err := b.client.Post(ev.Channel, reply)
+	return err
+}
+
+func unsubscribe(user string, args []string, db *bolt.DB) string {
+	if len(args) != 1 {
+		return "Specify subscription number or `all`"
+	}
+	if args[0] == "all" {
+		n, err := deleteSubscriptions(user, 0, db)
+		if err != nil {
+			return "Can't unsubscribe: " + err.Error()
+		}
+		return fmt.Sprintf("Removed %d subscription(s)", n)
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(args[0], "#"), 10, 64)
+	if err != nil {
+		return "Wrong subscription number: " + args[0]
+	}
+	n, err := deleteSubscriptions(user, id, db)
+	if err != nil {
+		return "Can't unsubscribe: " + err.Error()
+	}
+	number := "#" + strconv.FormatUint(id, 10)
+	if n == 0 {
+		return "No subscription " + number
+	}
+	return "Unsubscribed " + number
+}
+
+// notifySubscribers queues a DM with a link to the repost to every user with
+// a matching subscription, at most once per posting and AlertsPerDay a day.
+func (b *Bot) notifySubscribers(p posting, link string) {
+	subs, err := listSubscriptions("", b.store)
+	if err != nil {
+		b.log.Error("Can't get subscriptions", "error", err)
+		return
+	}
+	notified := make(map[string]bool)
+	var alerts []job
+	for _, s := range subs {
+		if notified[s.User] || s.User == p.User || !s.matches(p) {
+			continue
+		}
+		notified[s.User] = true
+		if !b.takeAlert(s.User) {
+			continue
+		}
+		text := fmt.Sprintf("Vacancy matching your subscription %s\n%s\n>%s", s, link, preview(p.Text))
+		alerts = append(alerts, job{Kind: jobDM, User: s.User, Text: text})
+	}
+	if len(alerts) == 0 {
+		return
+	}
+	if err := b.schedule(alerts); err != nil {
+		b.log.Error("Can't queue alerts", "error", err)
+	}
+}
+
+func preview(text string) string {
+	text = strings.SplitN(text, "\n", 2)[0]
+	runes := []rune(text)
+	if len(runes) > previewLength {
+		return string(runes[:previewLength]) + "…"
+	}
+	return text
+}
+
+func subscriptionKey(user string, id uint64) []byte {
+	return []byte(user + "/" + strconv.FormatUint(id, 10))
+}
+
+func saveSubscription(s subscription, db *bolt.DB) (subscription, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(subscriptionsBucket))
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		s.ID = id
+		value, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(subscriptionKey(s.User, s.ID), value)
+	})
+	return s, err
+}
+
+// listSubscriptions returns subscriptions of the user, or of everyone when
+// user is empty, ordered by ID.
+func listSubscriptions(user string, db *bolt.DB) ([]subscription, error) {
+	var result []subscription
+	prefix := ""
+	if user != "" {
+		prefix = user + "/"
+	}
+	err := db.View(func(tx *bolt.Tx) error {
+		cur := tx.Bucket([]byte(subscriptionsBucket)).Cursor()
+		for k, v := cur.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, v = cur.Next() {
+			var s subscription
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			result = append(result, s)
+		}
+		return nil
+	})
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result, err
+}
+
+// deleteSubscriptions removes the subscription with the ID, or all
+// subscriptions of the user when id is 0, and returns how many were removed.
+func deleteSubscriptions(user string, id uint64, db *bolt.DB) (int, error) {
+	list, err := listSubscriptions(user, db)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(subscriptionsBucket))
+		for _, s := range list {
+			if id != 0 && s.ID != id {
+				continue
+			}
+			if err := bucket.Delete(subscriptionKey(user, s.ID)); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// takeAlert counts an alert for the user today and reports whether it is
+// still within the daily limit. A limit of 0 means no limit. Counts of
+// previous days aren't needed anymore and are removed.
+func (b *Bot) takeAlert(user string) bool {
+	limit := b.cfg.AlertsPerDay
+	prefix := user + "/"
+	key := []byte(prefix + b.now().UTC().Format("2006-01-02"))
+	allowed := false
+	err := b.store.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(alertsBucket))
+		var old [][]byte
+		cur := bucket.Cursor()
+		for k, _ := cur.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, _ = cur.Next() {
+			if !bytes.Equal(k, key) {
+				old = append(old, k)
+			}
+		}
+		for _, k := range old {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		count, _ := strconv.Atoi(string(bucket.Get(key)))
+		if limit > 0 && count >= limit {
+			return nil
+		}
+		allowed = true
+		return bucket.Put(key, []byte(strconv.Itoa(count+1)))
+	})
+	if err != nil {
+		b.log.Error("Can't count alert", "user", user, "error", err)
+		return false
+	}
+	return allowed
+}
@@ -0,0 +1,165 @@
+package bot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/nlopes/slack"
+)
+
+func TestParseSubscription(t *testing.T) {
+	s, err := parseSubscription("U1", strings.Fields("Remote automation Python tag:mobile location:Москва"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := subscription{
+		User:      "U1",
+		Keywords:  []string{"automation", "python"},
+		Tags:      []string{"mobile"},
+		Locations: []string{"moscow"},
+		Remote:    true,
+	}
+	if !reflect.DeepEqual(s, expected) {
+		t.Errorf("Actual result: %+v, expected: %+v", s, expected)
+	}
+
+	if _, err := parseSubscription("U1", nil); err == nil {
+		t.Error("Subscription without filters shouldn't be accepted")
+	}
+}
+
+func TestSubscriptionMatches(t *testing.T) {
+	p := posting{
+		Text: "Senior QA Automation for Google Chrome, Python + pytest, удалённо или Москва, тестирование API",
+		Tags: []string{"automation"},
+		Fields: fields{
+			Remote:    true,
+			Locations: []string{"Moscow"},
+			Stack:     []string{"Python", "pytest"},
+		},
+	}
+
+	cases := []struct {
+		in  string
+		res bool
+	}{
+		{"remote automation python", true},
+		{"tag:automation location:moscow", true},
+		{"location:москва", true},
+		{"relocation", false},
+		{"tag:mobile", false},
+		{"remote java", false},
+		{"google", true},
+		{"go", false},
+		{"тестировщик", false},
+		{"тестированию", true},
+		{"location:rome", false},
+	}
+
+	for _, v := range cases {
+		s, _ := parseSubscription("U1", strings.Fields(v.in))
+		result := s.matches(p)
+		if result != v.res {
+			t.Errorf("For subscription: %s, actual result: %v, expected: %v", v.in, result, v.res)
+		}
+	}
+}
+
+func TestSubscriptionCommands(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+
+	recorder := newFakeSlack()
+	client := newTestBot(t, recorder, db, Config{})
+	command := func(text string) string {
+		err := client.HandleCommand(&slack.MessageEvent{Msg: slack.Msg{Channel: "D1", User: "U1", Text: text}})
+		if err != nil {
+			t.Fatal("Can't handle command: ", err)
+		}
+		replies := recorder.markdownPosts()["D1"]
+		return replies[len(replies)-1]
+	}
+
+	if reply := command("subscribe remote python"); reply != "Subscribed #1: remote python" {
+		t.Errorf("Actual reply: %s", reply)
+	}
+	command("subscribe tag:security")
+	if reply := command("list"); reply != "#1: remote python\n#2: tag:security" {
+		t.Errorf("Actual reply: %s", reply)
+	}
+	if reply := command("unsubscribe #1"); reply != "Unsubscribed #1" {
+		t.Errorf("Actual reply: %s", reply)
+	}
+	if reply := command("unsubscribe all"); reply != "Removed 1 subscription(s)" {
+		t.Errorf("Actual reply: %s", reply)
+	}
+	if reply := command("list"); reply != "You have no subscriptions" {
+		t.Errorf("Actual reply: %s", reply)
+	}
+	if reply := command("hello"); reply != commandHelp {
+		t.Errorf("Actual reply: %s", reply)
+	}
+
+	err := client.HandleCommand(&slack.MessageEvent{Msg: slack.Msg{Channel: "C1", User: "U1", Text: "list"}})
+	if err == nil || err.Error() != notDirectMessage {
+		t.Errorf("Actual error: %v, expected: %s", err, notDirectMessage)
+	}
+	err = client.HandleCommand(&slack.MessageEvent{Msg: slack.Msg{Channel: "D1", User: "UBOT", Text: "list"}})
+	if err == nil || err.Error() != wrongUserID {
+		t.Errorf("Actual error: %v, expected: %s", err, wrongUserID)
+	}
+}
+
+func TestNotifySubscribersWithDailyLimit(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+
+	for _, s := range []subscription{
+		{User: "U1", Keywords: []string{"python"}},
+		{User: "U1", Remote: true},
+		{User: "U2", Keywords: []string{"java"}},
+	} {
+		if _, err := saveSubscription(s, db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recorder := newFakeSlack()
+	client := newTestBot(t, recorder, db, Config{AlertsPerDay: 2})
+	now := time.Date(2018, time.March, 19, 10, 0, 0, 0, time.UTC)
+	client.now = func() time.Time { return now }
+	p := posting{Text: "Remote python developer in test", Fields: fields{Remote: true}}
+	for i := 0; i < 3; i++ {
+		client.notifySubscribers(p, "https://qa.slack.com/archives/C1/p1")
+	}
+	if n := len(recorder.posts()); n != 0 {
+		t.Errorf("Alerts should wait in the outbox, actual DMs: %d", n)
+	}
+	client.deliverDue()
+
+	if n := len(recorder.posts()["DU1"]); n != 2 {
+		t.Errorf("Actual number of alerts: %d, expected: 2", n)
+	}
+	if n := len(recorder.posts()["DU2"]); n != 0 {
+		t.Errorf("Actual number of alerts: %d, expected: 0", n)
+	}
+	if alerts := recorder.markdownPosts()["DU1"]; len(alerts) == 0 || !strings.Contains(alerts[0], "https://qa.slack.com/archives/C1/p1") {
+		t.Errorf("Alert should contain link: %q", alerts)
+	}
+
+	now = now.AddDate(0, 0, 1)
+	client.notifySubscribers(p, "https://qa.slack.com/archives/C1/p1")
+	client.deliverDue()
+	if n := len(recorder.posts()["DU1"]); n != 3 {
+		t.Errorf("Limit should reset next day, actual number of alerts: %d, expected: 3", n)
+	}
+	db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket([]byte(alertsBucket)).Stats().KeyN; n != 1 {
+			t.Errorf("Counts of previous days should be removed, actual number: %d", n)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,150 @@
+package bot
+
+import (
+	"log/slog"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+const usersPageSize = "200"
+
+// UserDirectory looks up users of the workspace, a page at a time for
+// the whole list.
+type UserDirectory interface {
+	GetUserInfo(id string) (*slack.User, error)
+	GetUsersPage(cursor string) ([]slack.User, string, error)
+}
+
+// userCache maps user IDs to names. Unknown IDs are fetched on demand and
+// users Slack doesn't know aren't looked up again until the next full
+// refresh; other failed lookups are retried.
+type userCache struct {
+	mu      sync.RWMutex
+	names   map[string]string
+	missing map[string]bool
+	dir     UserDirectory
+	log     *slog.Logger
+}
+
+func newUserCache(dir UserDirectory, log *slog.Logger) *userCache {
+	if log == nil {
+		log = slog.Default()
+	}
+	return &userCache{
+		names:   make(map[string]string),
+		missing: make(map[string]bool),
+		dir:     dir,
+		log:     log,
+	}
+}
+
+func (c *userCache) Name(id string) (string, bool) {
+	c.mu.RLock()
+	name, ok := c.names[id]
+	missing := c.missing[id]
+	c.mu.RUnlock()
+	if ok || missing || c.dir == nil {
+		return name, ok
+	}
+
+	user, err := c.dir.GetUserInfo(id)
+	if err != nil {
+		c.log.Warn("Can't get user info", "user", id, "error", err)
+		if e, ok := err.(*apiError); ok && e.Code == "user_not_found" {
+			c.mu.Lock()
+			c.missing[id] = true
+			c.mu.Unlock()
+		}
+		return "", false
+	}
+	c.Set(user.ID, user.Name)
+	return user.Name, true
+}
+
+func (c *userCache) IDByName(name string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for id, n := range c.names {
+		if n == name {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+func (c *userCache) Set(id, name string) {
+	c.mu.Lock()
+	c.names[id] = name
+	delete(c.missing, id)
+	c.mu.Unlock()
+}
+
+// Refresh reloads the whole directory page by page. The cache is only
+// replaced once every page has been fetched.
+func (c *userCache) Refresh() error {
+	if c.dir == nil {
+		return nil
+	}
+	names := make(map[string]string)
+	cursor := ""
+	for {
+		users, next, err := c.dir.GetUsersPage(cursor)
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			names[user.ID] = user.Name
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	c.mu.Lock()
+	c.names = names
+	c.missing = make(map[string]bool)
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *userCache) RefreshEvery(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if err := c.Refresh(); err != nil {
+			c.log.Error("Can't refresh list of users", "error", err)
+		}
+	}
+}
+
+func (c SlackClient) GetUserInfo(id string) (*slack.User, error) {
+	var resp struct {
+		User *slack.User `json:"user"`
+	}
+	_, err := c.callSlack("users.info", url.Values{"user": {id}}, &resp)
+	return resp.User, err
+}
+
+func (c SlackClient) GetUsersPage(cursor string) ([]slack.User, string, error) {
+	values := url.Values{"limit": {usersPageSize}}
+	if cursor != "" {
+		values.Set("cursor", cursor)
+	}
+	var resp struct {
+		Members []slack.User `json:"members"`
+	}
+	reply, err := c.callSlack("users.list", values, &resp)
+	if err != nil {
+		return nil, "", err
+	}
+	return resp.Members, reply.Metadata.NextCursor, nil
+}
@@ -0,0 +1,128 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/nlopes/slack"
+)
+
+type testDirectory struct {
+	mu    sync.Mutex
+	pages [][]slack.User
+	info  map[string]string
+	calls int
+}
+
+func (d *testDirectory) GetUserInfo(id string) (*slack.User, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls++
+	name, ok := d.info[id]
+	switch {
+	case id == "U500":
+		return nil, errors.New("timeout")
+	case !ok:
+		return nil, &apiError{Method: "users.info", Code: "user_not_found"}
+	}
+	return &slack.User{ID: id, Name: name}, nil
+}
+
+func (d *testDirectory) GetUsersPage(cursor string) ([]slack.User, string, error) {
+	page, _ := strconv.Atoi(cursor)
+	next := ""
+	if page+1 < len(d.pages) {
+		next = strconv.Itoa(page + 1)
+	}
+	return d.pages[page], next, nil
+}
+
+func TestUserCacheRefreshFollowsCursor(t *testing.T) {
+	dir := &testDirectory{
+		pages: [][]slack.User{
+			{{ID: "U1", Name: "vasya"}},
+			{{ID: "U2", Name: "petya"}},
+			{{ID: "W3", Name: "bot"}},
+		},
+	}
+	cache := newUserCache(dir, nil)
+	if err := cache.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, expected := range map[string]string{"U1": "vasya", "U2": "petya", "W3": "bot"} {
+		name, ok := cache.Name(id)
+		if !ok || name != expected {
+			t.Errorf("For ID: %s, actual result: %s, expected: %s", id, name, expected)
+		}
+	}
+	if id, _ := cache.IDByName("bot"); id != "W3" {
+		t.Errorf("Actual bot ID: %s, expected: W3", id)
+	}
+}
+
+func TestUserCacheFetchesUnknownUsersOnce(t *testing.T) {
+	dir := &testDirectory{
+		pages: [][]slack.User{{}},
+		info:  map[string]string{"U1": "vasya"},
+	}
+	cache := newUserCache(dir, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.Name("U1")
+			cache.Name("U404")
+		}()
+	}
+	wg.Wait()
+
+	if name, ok := cache.Name("U1"); !ok || name != "vasya" {
+		t.Errorf("Actual result: %s, expected: vasya", name)
+	}
+	calls := dir.calls
+	cache.Name("U1")
+	cache.Name("U404")
+	if dir.calls != calls {
+		t.Errorf("Cached users shouldn't be fetched again, calls: %d, expected: %d", dir.calls, calls)
+	}
+
+	cache.Name("U500")
+	cache.Name("U500")
+	if dir.calls != calls+2 {
+		t.Errorf("Users should be fetched again after an error, calls: %d, expected: %d", dir.calls, calls+2)
+	}
+}
+
+func TestUserCacheUpdatedByEvents(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+	client := newFakeSlack()
+	b := newTestBot(t, client, db, Config{Routes: []*Route{testRoute("C1", "C2")}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan slack.RTMEvent)
+	done := make(chan error)
+	go func() {
+		done <- b.serve(ctx, events)
+	}()
+	events <- slack.RTMEvent{Type: "team_join", Data: &slack.TeamJoinEvent{User: slack.User{ID: "U5", Name: "vasya"}}}
+	events <- slack.RTMEvent{Type: "user_change", Data: &slack.UserChangeEvent{User: slack.User{ID: "U5", Name: "vasily"}}}
+	events <- slack.RTMEvent{Type: "team_join", Data: &slack.TeamJoinEvent{User: slack.User{ID: "U6", Name: "petya"}}}
+	cancel()
+	<-done
+
+	for id, expected := range map[string]string{"U5": "vasily", "U6": "petya"} {
+		if name, ok := b.users.Name(id); !ok || name != expected {
+			t.Errorf("For ID: %s, actual result: %s, expected: %s", id, name, expected)
+		}
+	}
+	if n := client.calls["users.info"]; n != 0 {
+		t.Errorf("Users from events shouldn't be fetched, calls: %d", n)
+	}
+}
@@ -0,0 +1,277 @@
+package bot
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/nlopes/slack"
+)
+
+const (
+	deliveriesBucket = "WEBHOOK_DELIVERIES"
+	// deliveriesRetention is how long results of deliveries are kept.
+	deliveriesRetention = 30 * 24 * time.Hour
+
+	eventJobReposted     = "job.reposted"
+	eventJobUpdated      = "job.updated"
+	eventJobDeleted      = "job.deleted"
+	eventJobSkipped      = "job.skipped"
+	eventDeletedInTarget = "message.deleted_in_target"
+
+	signatureHeader = "X-QA-Bot-Signature"
+	timestampHeader = "X-QA-Bot-Timestamp"
+	eventHeader     = "X-QA-Bot-Event"
+)
+
+var webhookEvents = []string{eventJobReposted, eventJobUpdated, eventJobDeleted, eventJobSkipped, eventDeletedInTarget}
+
+// Webhook is an HTTP endpoint that gets a signed JSON payload on every
+// event it subscribes to, or on every event without a list.
+type Webhook struct {
+	Name       string   `json:"name"`
+	URL        string   `json:"url"`
+	Secret     string   `json:"secret"`
+	SecretFile string   `json:"secret_file"`
+	Events     []string `json:"events"`
+}
+
+func (w *Webhook) compile() error {
+	if w.Name == "" || w.URL == "" {
+		return errors.New("Webhook needs a name and a URL")
+	}
+	var err error
+	if w.Secret, err = ReadSecret(w.Secret, w.SecretFile); err != nil {
+		return errors.New("Can't read secret of webhook " + w.Name + ": " + err.Error())
+	}
+	for _, e := range w.Events {
+		if !contains(webhookEvents, e) {
+			return errors.New("Unknown event " + e + " of webhook " + w.Name + ", use " + strings.Join(webhookEvents, ", "))
+		}
+	}
+	return nil
+}
+
+func (w *Webhook) wants(event string) bool {
+	return len(w.Events) == 0 || contains(w.Events, event)
+}
+
+// webhookEvent is the JSON payload of a webhook. Posting events carry the
+// posting with extracted fields, a deletion in a target channel the
+// channel, the message and its author.
+type webhookEvent struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	Time      time.Time `json:"time"`
+	Workspace string    `json:"workspace,omitempty"`
+	Posting   *posting  `json:"posting,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Channel   string    `json:"channel,omitempty"`
+	Timestamp string    `json:"ts,omitempty"`
+	User      string    `json:"user,omitempty"`
+}
+
+// delivery is the result of a webhook job, kept in the store.
+type delivery struct {
+	Job       uint64    `json:"job"`
+	Webhook   string    `json:"webhook"`
+	Event     string    `json:"event"`
+	Delivered bool      `json:"delivered"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// emit queues the event for every webhook subscribed to it. The outbox
+// delivers it off the message path and retries failed deliveries.
+func (b *Bot) emit(e webhookEvent) {
+	if len(b.cfg.Webhooks) == 0 {
+		return
+	}
+	e.Time = b.now().UTC()
+	e.Workspace = b.teamURL
+	var jobs []job
+	for _, w := range b.cfg.Webhooks {
+		if !w.wants(e.Event) {
+			continue
+		}
+		e.ID = newDeliveryID()
+		body, err := json.Marshal(e)
+		if err != nil {
+			b.log.Error("Can't encode webhook event", "event", e.Event, "error", err)
+			return
+		}
+		jobs = append(jobs, job{Kind: jobWebhook, Destination: w.Name, Text: string(body)})
+	}
+	if len(jobs) == 0 {
+		return
+	}
+	if err := b.schedule(jobs); err != nil {
+		b.log.Error("Can't queue webhook event", "event", e.Event, "error", err)
+	}
+}
+
+func newDeliveryID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// eventName reads the event of a queued payload.
+func eventName(body string) string {
+	var e webhookEvent
+	json.Unmarshal([]byte(body), &e)
+	return e.Event
+}
+
+func (b *Bot) callWebhook(j job) error {
+	for _, w := range b.cfg.Webhooks {
+		if w.Name == j.Destination {
+			return postEvent(b.cfg.Workspace, w, j.Text, b.now())
+		}
+	}
+	return &apiError{Method: j.Destination, Code: "unknown_webhook"}
+}
+
+// signature signs the timestamp and the body the way Slack signs its
+// requests: hex HMAC-SHA256 of "v0:<timestamp>:<body>" prefixed with "v0=".
+func signature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// postEvent sends the payload. A 4xx reply other than 408 and 429 means
+// the receiver rejects it and retrying won't help.
+func postEvent(workspace string, w *Webhook, body string, now time.Time) error {
+	method := "webhook " + w.Name
+	req, err := http.NewRequest("POST", w.URL, strings.NewReader(body))
+	if err != nil {
+		return &apiError{Method: method, Code: err.Error()}
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(eventHeader, eventName(body))
+	req.Header.Set(timestampHeader, timestamp)
+	if w.Secret != "" {
+		req.Header.Set(signatureHeader, signature(w.Secret, timestamp, []byte(body)))
+	}
+	return observeAPI(workspace, method, func() error {
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode == http.StatusTooManyRequests:
+			seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+			return &rateLimitError{Method: method, RetryAfter: time.Duration(seconds) * time.Second}
+		case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout:
+			return &apiError{Method: method, Code: resp.Status}
+		}
+		return errors.New(method + ": " + resp.Status)
+	})
+}
+
+// recordDelivery keeps the result of a delivered or dropped webhook job and
+// removes results older than the retention.
+func (b *Bot) recordDelivery(j job, delivered bool) {
+	d := delivery{
+		Job:       j.ID,
+		Webhook:   j.Destination,
+		Event:     eventName(j.Text),
+		Delivered: delivered,
+		Attempts:  j.Attempts,
+		LastError: j.LastError,
+		Time:      b.now(),
+	}
+	if delivered {
+		d.Attempts++
+	}
+	err := b.store.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(deliveriesBucket))
+		value, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(jobKey(j.ID), value); err != nil {
+			return err
+		}
+		// Keys follow job IDs, so the oldest results come first.
+		var expired [][]byte
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var old delivery
+			if json.Unmarshal(v, &old) == nil && !old.Time.Before(d.Time.Add(-deliveriesRetention)) {
+				break
+			}
+			expired = append(expired, k)
+		}
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.log.Error("Can't record webhook delivery", "job", j.ID, "error", err)
+	}
+}
+
+func listDeliveries(db *bolt.DB) ([]delivery, error) {
+	var result []delivery
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(deliveriesBucket)).ForEach(func(k, v []byte) error {
+			var d delivery
+			if err := json.Unmarshal(v, &d); err != nil {
+				return err
+			}
+			result = append(result, d)
+			return nil
+		})
+	})
+	return result, err
+}
+
+// trackSource emits events about edits and deletions of source messages
+// that were reposted.
+func (b *Bot) trackSource(ev *slack.MessageEvent) {
+	if len(b.cfg.Webhooks) == 0 {
+		return
+	}
+	var ts, event, text string
+	switch {
+	case ev.SubType == "message_changed" && ev.SubMessage != nil:
+		ts, event, text = ev.SubMessage.Timestamp, eventJobUpdated, ev.SubMessage.Text
+	case ev.SubType == "message_deleted":
+		ts, event = ev.DeletedTimestamp, eventJobDeleted
+	default:
+		return
+	}
+	p, err := findPosting(ev.Channel, ts, b.store)
+	if err != nil {
+		b.log.Error("Can't find reposted message", "channel", ev.Channel, "ts", ts, "error", err)
+		return
+	}
+	if p == nil {
+		return
+	}
+	if event == eventJobUpdated {
+		p.Text = b.formatMessage(text)
+		p.Fields = extractFields(p.Text)
+		p.Tags = tagPosting(p.Text, b.cfg.Categories)
+	}
+	b.emit(webhookEvent{Event: event, Posting: p})
+}
@@ -0,0 +1,223 @@
+package bot
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/nlopes/slack"
+)
+
+func TestWebhookCompile(t *testing.T) {
+	secret := writeFile(t, "secret", "s3cret\n")
+	cases := []struct {
+		w   Webhook
+		err string
+	}{
+		{Webhook{Name: "board", URL: "https://jobs.example.com/hook", SecretFile: secret, Events: []string{eventJobReposted}}, ""},
+		{Webhook{Name: "board"}, "Webhook needs a name and a URL"},
+		{Webhook{Name: "board", URL: "https://jobs.example.com/hook", Secret: "x", SecretFile: secret}, "Can't read secret of webhook board: Set either the secret or the file with it, not both"},
+		{Webhook{Name: "board", URL: "https://jobs.example.com/hook", Events: []string{"job.created"}}, "Unknown event job.created of webhook board, use job.reposted, job.updated, job.deleted, job.skipped, message.deleted_in_target"},
+	}
+
+	for i, v := range cases {
+		err := cases[i].w.compile()
+		if v.err == "" && err != nil || v.err != "" && (err == nil || err.Error() != v.err) {
+			t.Errorf("For webhook %+v, actual error: %v, expected: %s", v.w, err, v.err)
+		}
+	}
+	if cases[0].w.Secret != "s3cret" {
+		t.Errorf("Actual secret: %q", cases[0].w.Secret)
+	}
+}
+
+// eventReceiver checks signatures of events and replies with the statuses
+// in order, then with 200.
+type eventReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	events   []webhookEvent
+}
+
+func newEventReceiver(t *testing.T, secret string, statuses ...int) *eventReceiver {
+	e := &eventReceiver{statuses: statuses}
+	e.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if secret != "" && r.Header.Get(signatureHeader) != signature(secret, r.Header.Get(timestampHeader), body) {
+			t.Errorf("Wrong signature of %s", body)
+		}
+		var ev webhookEvent
+		if err := json.Unmarshal(body, &ev); err != nil || ev.Event != r.Header.Get(eventHeader) {
+			t.Errorf("Wrong payload %s with event header %s", body, r.Header.Get(eventHeader))
+		}
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if len(e.statuses) > 0 {
+			status := e.statuses[0]
+			e.statuses = e.statuses[1:]
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+		}
+		e.events = append(e.events, ev)
+	}))
+	return e
+}
+
+func (e *eventReceiver) received() []webhookEvent {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]webhookEvent(nil), e.events...)
+}
+
+func TestWebhookEvents(t *testing.T) {
+	board := newEventReceiver(t, "s3cret")
+	defer board.Close()
+	sheet := newEventReceiver(t, "", http.StatusBadGateway)
+	defer sheet.Close()
+
+	db := openTestDB(t)
+	defer closeTestDB(db)
+	now := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	client := newFakeSlack()
+	cfg := Config{
+		BotName:    "bot",
+		Routes:     []*Route{testRoute("111", "333")},
+		Categories: DefaultCategories(),
+		Webhooks: []*Webhook{
+			{Name: "board", URL: board.URL, Secret: "s3cret"},
+			{Name: "sheet", URL: sheet.URL, Events: []string{eventJobDeleted}},
+		},
+	}
+	b, err := NewBot(cfg, Deps{Slack: client, Store: db, Now: func() time.Time { return now }})
+	if err != nil {
+		t.Fatal("Can't create bot: ", err)
+	}
+	b.selfID = fakeBotID
+	b.teamURL = "https://qa.slack.com/"
+
+	text := "Remote Selenium vacancy, 3000 USD http://hh.ru/vacancy/1"
+	ev := &slack.MessageEvent{Msg: slack.Msg{Channel: "111", User: "U1", Timestamp: "1.1", Text: text}}
+	if err := b.RepostMessage(ev); err != nil {
+		t.Fatal("Can't repost: ", err)
+	}
+	b.RepostMessage(&slack.MessageEvent{Msg: slack.Msg{Channel: "111", User: "U2", Timestamp: "1.2", Text: text}})
+	b.RepostMessage(&slack.MessageEvent{Msg: slack.Msg{Channel: "111", SubType: "message_changed"}, SubMessage: &slack.Msg{Timestamp: "1.1", Text: text + " Python"}})
+	b.RepostMessage(&slack.MessageEvent{Msg: slack.Msg{Channel: "111", SubType: "message_deleted", DeletedTimestamp: "1.1"}})
+	b.RepostMessage(&slack.MessageEvent{Msg: slack.Msg{Channel: "111", SubType: "message_deleted", DeletedTimestamp: "9.9"}})
+	ts := client.addMessage("333", "U3", "flood")
+	if err := b.DeleteMessage(&slack.MessageEvent{Msg: slack.Msg{Channel: "333", User: "U3", Timestamp: ts}}); err != nil {
+		t.Fatal("Can't delete: ", err)
+	}
+	if events := board.received(); len(events) != 0 {
+		t.Fatalf("Events should be delivered by the outbox, got: %+v", events)
+	}
+	b.deliverDue()
+
+	events := board.received()
+	var names []string
+	for _, e := range events {
+		names = append(names, e.Event)
+	}
+	want := []string{eventJobReposted, eventJobSkipped, eventJobUpdated, eventJobReposted, eventJobDeleted, eventDeletedInTarget}
+	if len(names) != len(want) {
+		t.Fatalf("Actual events: %v, expected: %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("Actual events: %v, expected: %v", names, want)
+		}
+	}
+	reposted := events[0]
+	if reposted.ID == "" || reposted.ID == events[1].ID || !reposted.Time.Equal(now) || reposted.Workspace != "https://qa.slack.com/" {
+		t.Errorf("Wrong event: %+v", reposted)
+	}
+	if p := reposted.Posting; p == nil || p.Text != text || p.Timestamp != "1.1" || !p.Fields.Remote || p.Fields.SalaryMin != 3000 || p.Link == "" {
+		t.Errorf("Wrong posting: %+v", reposted.Posting)
+	}
+	if e := events[1]; e.Reason != skipDuplicate || e.User != "U2" {
+		t.Errorf("Wrong skip: %+v", e)
+	}
+	if p := events[2].Posting; p == nil || p.Text != text+" Python" || !contains(p.Fields.Stack, "Python") {
+		t.Errorf("Wrong update: %+v", events[2].Posting)
+	}
+	if e := events[5]; e.Channel != "333" || e.Timestamp != ts || e.User != "U3" {
+		t.Errorf("Wrong deletion: %+v", e)
+	}
+
+	if len(sheet.received()) != 0 {
+		t.Fatalf("Failed webhook got: %+v", sheet.received())
+	}
+	now = now.Add(time.Hour)
+	jobs, _ := claimJobs(now, db)
+	if len(jobs) != 1 || jobs[0].Kind != jobWebhook || jobs[0].Destination != "sheet" {
+		t.Fatalf("Actual jobs to retry: %+v", jobs)
+	}
+	b.deliver(jobs)
+	if events := sheet.received(); len(events) != 1 || events[0].Event != eventJobDeleted {
+		t.Errorf("Retried webhook got: %+v", events)
+	}
+
+	deliveries, err := listDeliveries(db)
+	if err != nil {
+		t.Fatal("Can't list deliveries: ", err)
+	}
+	if len(deliveries) != 7 {
+		t.Fatalf("Actual deliveries: %+v", deliveries)
+	}
+	for _, d := range deliveries {
+		if d.Webhook == "sheet" && (d.Event != eventJobDeleted || !d.Delivered || d.Attempts != 2 || d.LastError == "") {
+			t.Errorf("Wrong delivery of retried webhook: %+v", d)
+		}
+	}
+}
+
+func TestFindPosting(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+	if err := savePosted("Manual QA", posting{Text: "Manual QA", Channel: "C1", Timestamp: "1.1"}, db); err != nil {
+		t.Fatal("Can't save posting: ", err)
+	}
+	if err := savePosted("Manual QA", posting{Text: "Manual QA", Channel: "C1", Timestamp: "1.2"}, db); err != nil {
+		t.Fatal("Can't save posting: ", err)
+	}
+	if p, err := findPosting("C1", "1.1", db); err != nil || p != nil {
+		t.Errorf("Replaced source is found: %+v, %v", p, err)
+	}
+
+	// The index is built for postings saved before it appeared.
+	db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte(sourcesBucket))
+	})
+	if err := createBuckets(db); err != nil {
+		t.Fatal("Can't create buckets: ", err)
+	}
+	if p, err := findPosting("C1", "1.2", db); err != nil || p == nil || p.Text != "Manual QA" {
+		t.Errorf("Actual posting: %+v, %v", p, err)
+	}
+}
+
+func TestDeliveriesRetention(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+	now := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	b, err := NewBot(Config{}, Deps{Slack: newFakeSlack(), Store: db, Now: func() time.Time { return now }})
+	if err != nil {
+		t.Fatal("Can't create bot: ", err)
+	}
+	b.recordDelivery(job{ID: 1, Destination: "board"}, true)
+	now = now.Add(deliveriesRetention + time.Hour)
+	b.recordDelivery(job{ID: 2, Destination: "board", Attempts: 10, LastError: "webhook board: 500"}, false)
+
+	deliveries, _ := listDeliveries(db)
+	if len(deliveries) != 1 || deliveries[0].Job != 2 || deliveries[0].Delivered || deliveries[0].Attempts != 10 {
+		t.Errorf("Actual deliveries: %+v", deliveries)
+	}
+}
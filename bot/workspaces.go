@@ -0,0 +1,29 @@
+package bot
+
+import "net/http"
+
+// WorkspacesHandler serves the handler of every bot under /<workspace>/,
+// metrics of the process and health checks, which fail if any bot is
+// unhealthy.
+func WorkspacesHandler(names []string, bots []*Bot) http.Handler {
+	mux := http.NewServeMux()
+	for i, name := range names {
+		mux.Handle("/"+name+"/", http.StripPrefix("/"+name, bots[i].Handler()))
+		mux.HandleFunc("/"+name+"/metrics", http.NotFound)
+	}
+	mux.HandleFunc("/metrics", metricsHandler(names, bots))
+	check := func(ready bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var problems []string
+			for i, b := range bots {
+				for _, p := range b.problems(ready) {
+					problems = append(problems, names[i]+": "+p)
+				}
+			}
+			writeProblems(w, problems)
+		}
+	}
+	mux.HandleFunc("/healthz", check(false))
+	mux.HandleFunc("/readyz", check(true))
+	return mux
+}
@@ -0,0 +1,123 @@
+package bot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+func TestWorkspacesDedup(t *testing.T) {
+	shared := openTestDB(t)
+	defer closeTestDB(shared)
+	var bots []*Bot
+	var clients []*fakeSlack
+	for i := 0; i < 2; i++ {
+		db := openTestDB(t)
+		defer closeTestDB(db)
+		client := newFakeSlack()
+		b, err := NewBot(Config{BotName: "bot", Routes: []*Route{testRoute("111", "333")}}, Deps{Slack: client, Store: db, Dedup: shared})
+		if err != nil {
+			t.Fatal("Can't create bot: ", err)
+		}
+		bots = append(bots, b)
+		clients = append(clients, client)
+	}
+
+	ev := &slack.MessageEvent{Msg: slack.Msg{Channel: "111", Text: "QA vacancy http://hh.ru/vacancy/1"}}
+	if err := bots[0].RepostMessage(ev); err != nil {
+		t.Fatal("Can't repost: ", err)
+	}
+	err := bots[1].RepostMessage(ev)
+	if err == nil || err.Error() != messageIsAlreadyPosted {
+		t.Errorf("Posting of one workspace is reposted by another, actual error: %v", err)
+	}
+	if len(clients[0].posts()["333"]) != 1 || len(clients[1].posts()["333"]) != 0 {
+		t.Errorf("Actual posts: %v and %v", clients[0].posts(), clients[1].posts())
+	}
+	// Own namespace of the second workspace doesn't get the posting.
+	if alreadyPosted(bots[0].formatMessage(ev.Text), bots[1].store) {
+		t.Error("Posting is saved to the DB of another workspace")
+	}
+}
+
+func TestWorkspacesDedupClaim(t *testing.T) {
+	shared := openTestDB(t)
+	defer closeTestDB(shared)
+	var bots []*Bot
+	var clients []*fakeSlack
+	for i := 0; i < 2; i++ {
+		db := openTestDB(t)
+		defer closeTestDB(db)
+		client := newFakeSlack()
+		cfg := Config{BotName: "bot", Workspace: "claim" + strconv.Itoa(i), Routes: []*Route{testRoute("111", "333")}}
+		b, err := NewBot(cfg, Deps{Slack: client, Store: db, Dedup: shared})
+		if err != nil {
+			t.Fatal("Can't create bot: ", err)
+		}
+		bots = append(bots, b)
+		clients = append(clients, client)
+	}
+
+	// A repost waiting in the outbox of one workspace blocks the others.
+	clients[0].fail("chat.postMessage", &rateLimitError{Method: "chat.postMessage", RetryAfter: time.Minute})
+	ev := &slack.MessageEvent{Msg: slack.Msg{Channel: "111", Timestamp: "1.1", Text: "QA vacancy http://hh.ru/vacancy/1"}}
+	if err := bots[0].RepostMessage(ev); err == nil || !strings.Contains(err.Error(), "rate limited") {
+		t.Fatalf("Repost should be queued, actual error: %v", err)
+	}
+	if err := bots[1].RepostMessage(ev); err == nil || err.Error() != messageIsAlreadyPosted {
+		t.Errorf("Queued posting of one workspace is reposted by another, actual error: %v", err)
+	}
+
+	// A repost given up releases the claim.
+	other := &slack.MessageEvent{Msg: slack.Msg{Channel: "111", Timestamp: "2.2", Text: "QA vacancy http://hh.ru/vacancy/2"}}
+	clients[0].fail("chat.postMessage", &apiError{Method: "chat.postMessage", Code: "channel_not_found"})
+	if err := bots[0].RepostMessage(other); err == nil {
+		t.Fatal("Repost should fail")
+	}
+	if err := bots[1].RepostMessage(other); err != nil {
+		t.Errorf("Posting dropped by one workspace isn't reposted by another, actual error: %v", err)
+	}
+	if len(clients[1].posts()["333"]) != 1 {
+		t.Errorf("Actual posts: %v", clients[1].posts())
+	}
+	if n := metricReposts.Value("claim1"); n != 1 {
+		t.Errorf("Actual reposts of the workspace: %v, expected: 1", n)
+	}
+}
+
+func TestWorkspacesHandler(t *testing.T) {
+	var bots []*Bot
+	for i := 0; i < 2; i++ {
+		db := openTestDB(t)
+		defer closeTestDB(db)
+		bots = append(bots, newTestBot(t, newFakeSlack(), db, Config{Routes: []*Route{testRoute("111", "333")}}))
+	}
+	handler := WorkspacesHandler([]string{"one", "two"}, bots)
+	bots[0].trackConnection(&slack.ConnectedEvent{ConnectionCount: 1})
+
+	cases := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/healthz", http.StatusOK, "ok"},
+		{"/readyz", http.StatusServiceUnavailable, "two: RTM is not connected"},
+		{"/one/readyz", http.StatusOK, "ok"},
+		{"/two/readyz", http.StatusServiceUnavailable, "RTM is not connected"},
+		{"/metrics", http.StatusOK, "qa_bot_rtm_connected{workspace=\"one\"} 1\nqa_bot_rtm_connected{workspace=\"two\"} 0\n"},
+		{"/one/metrics", http.StatusNotFound, ""},
+		{"/three/healthz", http.StatusNotFound, ""},
+	}
+	for _, v := range cases {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", v.path, nil))
+		if w.Code != v.status || !strings.Contains(w.Body.String(), v.body) {
+			t.Errorf("For %s, actual status: %d, body: %s", v.path, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/artemnikitin/qa-slack-bot/bot"
+	"github.com/boltdb/bolt"
+)
+
+// runStats implements the stats subcommand. It needs the DB file, so it
+// can't run next to a bot holding the lock; use the slash command then.
+func runStats(args []string) error {
+	fs := commandFlags("stats")
+	period := fs.String("period", bot.DefaultStatsPeriod, "Period to report on, e.g. 24h or 30d")
+	fs.Parse(args)
+	if _, err := loadSettings(fs, os.LookupEnv); err != nil {
+		return err
+	}
+
+	d, err := bot.ParsePeriod(*period)
+	if err != nil {
+		return err
+	}
+	db, err := openReadOnly(fs.Lookup("db").Value.String())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	now := time.Now()
+	report, err := bot.Stats(now.Add(-d), now, db)
+	if err != nil {
+		return err
+	}
+	fmt.Print(report)
+	return nil
+}
+
+// runSearch implements the search subcommand. Like stats it needs the DB
+// file, so it can't run next to a bot holding the lock. The DB is resolved
+// from flags, the environment and the config file like for the bot.
+func runSearch(args []string) error {
+	fs := commandFlags("search")
+	limit := fs.Int("limit", bot.SearchLimit, "Max number of results")
+	fs.Parse(args)
+	if _, err := loadSettings(fs, os.LookupEnv); err != nil {
+		return err
+	}
+
+	db, err := openReadOnly(fs.Lookup("db").Value.String())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	results, err := bot.Search(strings.Join(fs.Args(), " "), *limit, db)
+	if err != nil {
+		return err
+	}
+	fmt.Println(results)
+	return nil
+}
+
+func openReadOnly(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, errors.New("Can't open DB, is the bot running? " + err.Error())
+	}
+	return db, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNewLogHandler(t *testing.T) {
+	cases := []struct {
+		format, level string
+		res           string
+	}{
+		{"logfmt", "info", "level=INFO msg=test user=U1\n"},
+		{"json", "info", `"level":"INFO","msg":"test","user":"U1"}` + "\n"},
+		{"json", "warn", ""},
+		{"xml", "info", "error"},
+		{"json", "verbose", "error"},
+	}
+
+	for _, v := range cases {
+		var b bytes.Buffer
+		handler, err := newLogHandler(&b, v.format, v.level)
+		if err != nil {
+			if v.res != "error" {
+				t.Errorf("For %s/%s, unexpected error: %v", v.format, v.level, err)
+			}
+			continue
+		}
+		slog.New(handler).Info("test", "user", "U1")
+		if v.res == "error" || !strings.HasSuffix(b.String(), v.res) {
+			t.Errorf("For %s/%s, actual result: %q, expected suffix: %q", v.format, v.level, b.String(), v.res)
+		}
+	}
+}
@@ -1,293 +1,298 @@
 package main
 
 import (
-	"errors"
+	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
-	"regexp"
-	"strings"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/artemnikitin/qa-slack-bot/bot"
 	"github.com/boltdb/bolt"
 	"github.com/nlopes/slack"
 )
 
 var (
-	token       = flag.String("token", "", "Token for Slack")
-	fromChannel = flag.String("from", "", "Name of channel where to look for messages")
-	toChannel   = flag.String("to", "", "Name of channel where to post messages")
-	slackUser   = flag.String("user", "", "User name for Slack")
-	debug       = flag.Bool("debug", false, "Enable debug mode")
-
-	textKeywords         = []string{"ваканси", "работа", "позици", "тестировщик", "автоматизатор", "должность", "требования"}
-	linkKeywords         = []string{"hh.ru", "job", "linkedin.com/jobs", "position", "vacancy", "work", "career"}
-	exclusions           = []string{".slack.com", "linkedin.com/comm/profile", "linkedin.com/profile"}
-	fromID, toID, userID string
-	userMap              map[string]string
-)
-
-const (
-	bucket                 = "QA-SLACK"
-	regexURL               = "(http|https)://([\\w_-]+(?:(?:\\.[\\w_-]+)+))([\\w.,@?^=%&:/~+#-]*[\\w@?^=%&/~+#-])?"
-	regexEmail             = "([a-zA-Z0-9][-_.a-zA-Z0-9]*)(@[-_.a-zA-Z0-9]+)"
-	wrongChannelID         = "Wrong channel ID"
-	wrongUserID            = "Wrong user ID"
-	messageIsNotJobPosting = "Not job posting"
-	messageIsAlreadyPosted = "Already posted"
+	token          = flag.String("token", "", "Token for Slack")
+	tokenFile      = flag.String("token-file", "", "Path to file with token for Slack")
+	configPath     = flag.String("config", "", "Path to JSON config with routes and settings")
+	fromChannel    = flag.String("from", "", "Name or ID of channel where to look for messages")
+	toChannel      = flag.String("to", "", "Name or ID of channel where to post messages")
+	slackUser      = flag.String("user", "", "User name for Slack")
+	debug          = flag.Bool("debug", false, "Enable debug mode")
+	usersTTL       = flag.Duration("users-refresh", time.Hour, "How often to reload the list of users")
+	dbPath         = flag.String("db", "repost.db", "Path to DB file")
+	listenAddr     = flag.String("listen", "", "Address for HTTP server with slash commands, metrics and health checks, e.g. :8080")
+	slashToken     = flag.String("verification-token", "", "Verification token of Slack slash commands")
+	slashTokenFile = flag.String("verification-token-file", "", "Path to file with verification token of Slack slash commands")
+	alertsLimit    = flag.Int("alerts-per-day", 20, "Max number of subscription alerts a user gets per day, 0 for no limit")
+	logFormat      = flag.String("log-format", "logfmt", "Format of logs: logfmt or json")
+	workers        = flag.Int("workers", 4, "Number of messages processed at the same time")
+	queueSize      = flag.Int("queue-size", 100, "Number of messages waiting for each worker before reading of events pauses")
+	logLevel       = flag.String("log-level", "info", "Minimal level of logs: debug, info, warn or error")
 )
 
-type slacker interface {
-	Repost(string, string) error
-	Delete(string, string) error
-}
-
-type slackerClient struct {
-	Slack *slack.Client
-}
-
-func (c slackerClient) Repost(toID, text string) error {
-	params := slack.PostMessageParameters{
-		AsUser: true,
-	}
-	_, _, err := c.Slack.PostMessage(toID, text, params)
-	return err
-}
-
-func (c slackerClient) Delete(toID, timestamp string) error {
-	_, _, err := c.Slack.DeleteMessage(toID, timestamp)
-	return err
-}
-
-type slackClient struct {
-	Client  slacker
-	Storage *bolt.DB
-}
-
-func (c *slackClient) RepostMessage(ev *slack.MessageEvent, r []*regexp.Regexp) error {
-	if ev.Channel != fromID {
-		return errors.New(wrongChannelID)
-	}
-	if len(ev.Attachments) > 0 {
-		return errors.New(messageIsNotJobPosting)
-	}
-	text := ev.Text
-	if ev.SubMessage != nil && ev.SubMessage.Text != "" {
-		text = ev.SubMessage.Text
-	}
-	if !isJobPosting(text, r) {
-		return errors.New(messageIsNotJobPosting)
-	}
-	text = strings.Replace(text, "<", "", -1)
-	text = strings.Replace(text, ">", "", -1)
-	if strings.Contains(text, "@U") {
-		text = replaceIDWithNickname(text)
-	}
-	if alreadyPosted(text, c.Storage) {
-		return errors.New(messageIsAlreadyPosted)
-	}
-	savePosted(text, c.Storage)
-	err := c.Client.Repost(toID, text)
-	return err
-}
-
-func (c *slackClient) DeleteMessage(ev *slack.MessageEvent) error {
-	if ev.Channel != toID {
-		return errors.New(wrongChannelID)
-	}
-	if ev.User == userID {
-		return errors.New(wrongUserID)
-	}
-	err := c.Client.Delete(toID, ev.Timestamp)
-	return err
-}
-
 func main() {
-	flag.Parse()
-	log.SetFlags(log.Lshortfile | log.LstdFlags)
-
-	userMap = make(map[string]string)
-
-	if *token == "" || *fromChannel == "" || *toChannel == "" || *slackUser == "" {
-		fmt.Println("Specify correct flags")
-		flag.PrintDefaults()
-		os.Exit(1)
+	if len(os.Args) > 1 && os.Args[1] == "stats" {
+		if err := runStats(os.Args[2:]); err != nil {
+			fatal("Can't get stats", "error", err)
+		}
+		return
 	}
-
-	db, err := bolt.Open("repost.db", 0600, nil)
-	if err != nil {
-		log.Fatal("Can't open DB: ", err)
+	if len(os.Args) > 1 && os.Args[1] == "search" {
+		if err := runSearch(os.Args[2:]); err != nil {
+			fatal("Can't search", "error", err)
+		}
+		return
 	}
-	defer db.Close()
-	err = db.Update(func(tx *bolt.Tx) error {
-		_, err = tx.CreateBucketIfNotExists([]byte(bucket))
-		return err
-	})
-	if err != nil {
-		log.Fatal("Can't create bucket: ", err)
+	args := os.Args[1:]
+	var command string
+	switch {
+	case len(args) > 0 && args[0] == "doctor":
+		command, args = "doctor", args[1:]
+	case len(args) > 1 && args[0] == "config" && args[1] == "print":
+		command, args = "config print", args[2:]
 	}
-
-	var regexList []*regexp.Regexp
-	r, err := regexp.Compile(regexURL)
+	flag.CommandLine.Parse(args)
+	sources, err := loadSettings(flag.CommandLine, os.LookupEnv)
 	if err != nil {
-		log.Fatal("Can't compile regexp: ", err)
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if command == "config print" {
+		printSettings(flag.CommandLine, sources, os.Stdout)
+		if *configPath != "" {
+			if err := printConfig(*configPath, os.Stdout); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+		return
 	}
-	regexList = append(regexList, r)
 
-	r, err = regexp.Compile(regexEmail)
+	handler, err := newLogHandler(os.Stderr, *logFormat, *logLevel)
 	if err != nil {
-		log.Fatal("Can't compile regexp: ", err)
+		fmt.Println(err)
+		os.Exit(1)
 	}
-	regexList = append(regexList, r)
-
-	api := slack.New(*token)
-	api.SetDebug(*debug)
-	client := &slackClient{
-		Client: slackerClient{
-			Slack: api,
-		},
-		Storage: db,
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+
+	var workspaces []*workspace
+	var dedup bool
+	if *configPath != "" {
+		workspaces, dedup, err = loadWorkspaces(*configPath, *dbPath)
+		if err != nil {
+			fatal("Can't load config", "error", err)
+		}
 	}
-
-	getSlackUserID(api)
-	getSlackChannelID(api)
-
-	rtm := api.NewRTM()
-	go rtm.ManageConnection()
-
-	for {
-		msg := <-rtm.IncomingEvents
-		fmt.Println("Event Received")
-		switch ev := msg.Data.(type) {
-		case *slack.MessageEvent:
-			client.RepostMessage(ev, regexList)
-			client.DeleteMessage(ev)
-
-		case *slack.RTMError:
-			fmt.Printf("Error: %s\n", ev.Error())
-
-		case *slack.InvalidAuthEvent:
-			fmt.Println("Error: Invalid credentials!")
-			break
+	if len(workspaces) == 0 {
+		cfg := &bot.Config{Categories: bot.DefaultCategories()}
+		if *configPath != "" {
+			cfg, err = bot.LoadConfig(*configPath)
+			if err != nil {
+				fatal("Can't load config", "error", err)
+			}
+		}
+		// A config file with settings only uses the route given by flags.
+		if len(cfg.Routes) == 0 && *fromChannel != "" && *toChannel != "" {
+			cfg.Routes = []*bot.Route{{
+				Name: "default",
+				From: []string{*fromChannel},
+				To:   []string{*toChannel},
+			}}
+		}
+		if *token == "" || *slackUser == "" || len(cfg.Routes) == 0 {
+			fmt.Println("Specify correct flags")
+			flag.PrintDefaults()
+			os.Exit(1)
 		}
+		workspaces = []*workspace{{Token: *token, DB: *dbPath, Config: *cfg}}
 	}
-
-}
-
-func isJobPosting(text string, r []*regexp.Regexp) bool {
-	text = strings.ToLower(text)
-	withKeyword := containsKeyword(text, textKeywords) || containsKeyword(text, linkKeywords)
-	skypeRule := strings.HasPrefix(text, "[skype -")
-
-	var regexValidated bool
-	for _, rgxp := range r {
-		if rgxp.MatchString(text) {
-			regexValidated = true
-			break
+	for _, ws := range workspaces {
+		if ws.User == "" {
+			ws.User = *slackUser
+		}
+		if ws.SlashToken == "" {
+			ws.SlashToken = *slashToken
 		}
+		if ws.User == "" {
+			fatal("Can't load config", "error", "workspace "+ws.Name+" has no user, set it in the config or with -user")
+		}
+		ws.BotName = ws.User
+		ws.Workspace = ws.Name
+		ws.AlertsPerDay = *alertsLimit
+		ws.UsersRefresh = *usersTTL
+		ws.Workers = *workers
+		ws.QueueSize = *queueSize
+		ws.VerificationToken = ws.SlashToken
 	}
 
-	var validatedText bool
-	if skypeRule {
-		validatedText = true
-	} else {
-		if regexValidated {
-			validatedText = withKeyword
+	if command == "doctor" {
+		dedupPath := ""
+		if dedup {
+			dedupPath = *dbPath
+		}
+		code := 0
+		for _, ws := range workspaces {
+			if ws.Name != "" {
+				fmt.Println("Workspace " + ws.Name)
+			}
+			if c := bot.Doctor(ws.Config, newSlacker(ws.Token, ws.Name), ws.DB, dedupPath, os.Stdout); c != 0 {
+				code = c
+			}
 		}
+		os.Exit(code)
 	}
+	os.Exit(run(workspaces, dedup, logger))
+}
 
-	return validatedText && validateExclusions(text)
+func newSlacker(token, workspace string) bot.SlackClient {
+	c := bot.NewSlackClient(token, slack.SLACK_API, nil)
+	c.Workspace = workspace
+	c.Slack.SetDebug(*debug)
+	return c
 }
 
-func containsKeyword(text string, list []string) bool {
-	result := false
-	for _, v := range list {
-		if strings.Contains(text, v) {
-			result = true
-			break
+// run wires a bot per workspace to Slack, the DB and the HTTP server and
+// blocks until it is stopped by a signal or invalid credentials of any
+// workspace, then shuts everything down and returns the exit code.
+func run(workspaces []*workspace, dedup bool, logger *slog.Logger) int {
+	var shared *bolt.DB
+	if dedup {
+		db, err := openDB(*dbPath, logger)
+		if err != nil {
+			return 1
 		}
+		defer closeDB(db, logger)
+		shared = db
 	}
-	return result
-}
 
-func validateExclusions(text string) bool {
-	for _, v := range exclusions {
-		if strings.Contains(text, v) {
-			return false
+	var names []string
+	var bots []*bot.Bot
+	var rtms []*slack.RTM
+	var logs []*slog.Logger
+	for _, ws := range workspaces {
+		log := logger
+		if ws.Name != "" {
+			log = logger.With("workspace", ws.Name)
+		}
+		db, err := openDB(ws.DB, log)
+		if err != nil {
+			return 1
 		}
+		defer closeDB(db, log)
+
+		adapter := newSlacker(ws.Token, ws.Name)
+		b, err := bot.NewBot(ws.Config, bot.Deps{
+			Slack: adapter,
+			Store: db,
+			Dedup: shared,
+			Connect: func(token string) bot.Slacker {
+				return newSlacker(token, ws.Name)
+			},
+			Logger: log,
+		})
+		if err != nil {
+			log.Error("Can't create bot", "error", err)
+			return 1
+		}
+		if !bot.LogChecks(b.Check(), log) {
+			return 1
+		}
+		if err := b.Init(); err != nil {
+			log.Error("Can't start bot", "error", err)
+			return 1
+		}
+		names = append(names, ws.Name)
+		bots = append(bots, b)
+		rtms = append(rtms, adapter.Slack.NewRTM())
+		logs = append(logs, log)
 	}
-	return true
-}
 
-func replaceIDWithNickname(text string) string {
-	if len(text) <= 10 {
-		return text
-	}
-	count := strings.Count(text, "@U")
-	place := 0
-	start := 0
-	for i := 0; i < count; i++ {
-		if len(text[place:])-start >= 10 {
-			start := strings.Index(text, "@U")
-			place += start + 1
-			id := text[start+1 : start+10]
-			nickname, ok := userMap[id]
-			if ok {
-				text = strings.Replace(text, id, nickname, -1)
-			}
+	var server *http.Server
+	if *listenAddr != "" {
+		handler := bot.WorkspacesHandler(names, bots)
+		if len(bots) == 1 && names[0] == "" {
+			handler = bots[0].Handler()
 		}
+		server = &http.Server{Addr: *listenAddr, Handler: handler}
+		go func() {
+			if err := server.ListenAndServe(); err != http.ErrServerClosed {
+				fatal("HTTP server stopped", "error", err)
+			}
+		}()
 	}
-	return text
-}
 
-func getSlackUserID(api *slack.Client) {
-	users, err := api.GetUsers()
-	if err != nil {
-		log.Fatal("Can't get list of users:", err)
+	signals, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	ctx, stopBots := context.WithCancel(context.Background())
+	defer stopBots()
+	go func() {
+		select {
+		case <-signals.Done():
+		case <-ctx.Done():
+		}
+		// Signals are released before bots start to shut down, so a second
+		// one kills the process without waiting for the shutdown.
+		stopSignals()
+		stopBots()
+	}()
+	errs := make(chan error, len(bots))
+	for i := range bots {
+		go rtms[i].ManageConnection()
+		go func(b *bot.Bot, rtm *slack.RTM) {
+			err := b.Run(ctx, rtm.IncomingEvents)
+			// Invalid credentials of one workspace stop the whole process.
+			stopBots()
+			errs <- err
+		}(bots[i], rtms[i])
 	}
-	for _, user := range users {
-		userMap[user.ID] = user.Name
-		if user.Name == *slackUser {
-			userID = user.ID
+	var err error
+	for range bots {
+		if e := <-errs; e != nil {
+			err = e
 		}
 	}
-}
 
-func getSlackChannelID(api *slack.Client) {
-	channels, err := api.GetChannels(false)
-	if err != nil {
-		log.Fatal("Can't get list of channels:", err)
-	}
-	for _, channel := range channels {
-		switch channel.Name {
-		case *fromChannel:
-			fromID = channel.ID
-		case *toChannel:
-			toID = channel.ID
-		default:
+	if server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), bot.ShutdownTimeout)
+		if err := server.Shutdown(ctx); err != nil {
+			logger.Error("Can't stop HTTP server", "error", err)
 		}
+		cancel()
+	}
+	var wg sync.WaitGroup
+	for i, rtm := range rtms {
+		wg.Add(1)
+		go func(rtm *slack.RTM, log *slog.Logger) {
+			defer wg.Done()
+			bot.Disconnect(rtm, log)
+		}(rtm, logs[i])
+	}
+	wg.Wait()
+	code := 0
+	if err != nil {
+		code = 1
 	}
+	logger.Info("Stopped", "code", code)
+	return code
 }
 
-func alreadyPosted(text string, db *bolt.DB) bool {
-	err := db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(bucket))
-		bytes := bucket.Get([]byte(text))
-		if bytes != nil {
-			return errors.New("")
-		}
-		return nil
-	})
-	return err != nil
+func openDB(path string, log *slog.Logger) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		log.Error("Can't open DB", "path", path, "error", err)
+	}
+	return db, err
 }
 
-func savePosted(text string, db *bolt.DB) {
-	err := db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(bucket))
-		err := bucket.Put([]byte(text), []byte(text))
-		return err
-	})
-	if err != nil {
-		log.Println(err)
+func closeDB(db *bolt.DB, log *slog.Logger) {
+	if err := db.Close(); err != nil {
+		log.Error("Can't close DB", "path", db.Path(), "error", err)
 	}
 }
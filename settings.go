@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/artemnikitin/qa-slack-bot/bot"
+)
+
+const (
+	envPrefix  = "QA_BOT_"
+	configFlag = "config"
+	fileSuffix = "-file"
+	redacted   = "<redacted>"
+)
+
+// secretFlags hold credentials. Each can be read from a file given by the
+// flag with the "-file" suffix and is never printed.
+var secretFlags = []string{"token", "verification-token"}
+
+// secretKeys are keys of the config file holding credentials: tokens of
+// workspaces, destinations and the API, secrets of webhooks and URLs of
+// Slack incoming webhooks.
+var secretKeys = []string{"token", "verification_token", "secret", "webhook"}
+
+func isSecret(name string) bool {
+	return contains(secretFlags, name)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// envName returns the environment variable of the flag, e.g.
+// QA_BOT_VERIFICATION_TOKEN for -verification-token.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// loadSettings fills flags that weren't given on the command line from the
+// environment, then from the "settings" object of the config file, and reads
+// secrets from files. It returns where the value of every flag came from.
+func loadSettings(fs *flag.FlagSet, lookupEnv func(string) (string, bool)) (map[string]string, error) {
+	sources := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		sources[f.Name] = "default"
+	})
+	fs.Visit(func(f *flag.Flag) {
+		sources[f.Name] = "command line"
+	})
+
+	set := func(name, value, source string) error {
+		if err := fs.Set(name, value); err != nil {
+			return errors.New("Wrong value of " + name + " from " + source + ": " + err.Error())
+		}
+		sources[name] = source
+		return nil
+	}
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if value, ok := lookupEnv(envName(f.Name)); ok && err == nil && sources[f.Name] == "default" {
+			err = set(f.Name, value, "env "+envName(f.Name))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if path := fs.Lookup(configFlag); path != nil && path.Value.String() != "" {
+		settings, err := readSettings(path.Value.String())
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range settings {
+			if fs.Lookup(name) == nil || name == configFlag {
+				return nil, errors.New("Unknown setting in config file: " + name)
+			}
+			if sources[name] != "default" {
+				continue
+			}
+			if err := set(name, value, "config file"); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, name := range secretFlags {
+		file := fs.Lookup(name + fileSuffix)
+		if file == nil || file.Value.String() == "" {
+			continue
+		}
+		secret, err := bot.ReadSecret(fs.Lookup(name).Value.String(), file.Value.String())
+		if err != nil {
+			return nil, errors.New("Can't read " + name + ": " + err.Error())
+		}
+		if err := set(name, secret, "file "+file.Value.String()); err != nil {
+			return nil, err
+		}
+	}
+	return sources, nil
+}
+
+// commandFlags creates flags of a subcommand with the flags of the bot, so
+// settings like db resolve the same way for it with loadSettings.
+func commandFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	return fs
+}
+
+// readSettings reads flag values from the "settings" object of the config
+// file, e.g. {"settings": {"db": "/var/lib/bot/repost.db", "workers": 8}}.
+func readSettings(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var file struct {
+		Settings map[string]interface{} `json:"settings"`
+	}
+	d := json.NewDecoder(f)
+	d.UseNumber()
+	if err := d.Decode(&file); err != nil {
+		return nil, errors.New("Can't parse config file: " + err.Error())
+	}
+	result := make(map[string]string)
+	for name, value := range file.Settings {
+		result[name] = fmt.Sprint(value)
+	}
+	return result, nil
+}
+
+// printSettings writes the effective value and the source of every flag,
+// with secrets redacted.
+func printSettings(fs *flag.FlagSet, sources map[string]string, w io.Writer) {
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		names = append(names, f.Name)
+	})
+	sort.Strings(names)
+	for _, name := range names {
+		value := fs.Lookup(name).Value.String()
+		if isSecret(name) && value != "" {
+			value = redacted
+		}
+		fmt.Fprintf(w, "%s=%q\t# %s\n", name, value, sources[name])
+	}
+}
+
+// printConfig writes the config file without its settings, which
+// printSettings reports, with credentials redacted.
+func printConfig(path string, w io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var file map[string]interface{}
+	d := json.NewDecoder(f)
+	d.UseNumber()
+	if err := d.Decode(&file); err != nil {
+		return errors.New("Can't parse config file: " + err.Error())
+	}
+	delete(file, "settings")
+	if len(file) == 0 {
+		return nil
+	}
+	fmt.Fprintf(w, "# config file %s\n", path)
+	e := json.NewEncoder(w)
+	e.SetEscapeHTML(false)
+	e.SetIndent("", "  ")
+	return e.Encode(redactConfig(file))
+}
+
+func redactConfig(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if s, ok := value.(string); ok && s != "" && contains(secretKeys, key) {
+				v[key] = redacted
+			} else {
+				v[key] = redactConfig(value)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redactConfig(v[i])
+		}
+	}
+	return v
+}
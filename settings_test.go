@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/artemnikitin/qa-slack-bot/bot"
+	"github.com/boltdb/bolt"
+)
+
+func testFlags() *flag.FlagSet {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("token", "", "")
+	fs.String("token-file", "", "")
+	fs.String("config", "", "")
+	fs.String("db", "repost.db", "")
+	fs.Int("workers", 4, "")
+	fs.Bool("debug", false, "")
+	fs.String("log-level", "info", "")
+	return fs
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSettingsPrecedence(t *testing.T) {
+	config := writeFile(t, "config.json", `{"settings": {"db": "file.db", "workers": 8, "debug": true, "log-level": "warn"}}`)
+	secret := writeFile(t, "token", "xoxb-secret\n")
+	env := map[string]string{
+		"QA_BOT_CONFIG":     config,
+		"QA_BOT_WORKERS":    "6",
+		"QA_BOT_LOG_LEVEL":  "error",
+		"QA_BOT_TOKEN_FILE": secret,
+	}
+	fs := testFlags()
+	fs.Parse([]string{"-log-level", "debug"})
+
+	sources, err := loadSettings(fs, func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	})
+	if err != nil {
+		t.Fatal("Can't load settings: ", err)
+	}
+	cases := []struct {
+		name, value, source string
+	}{
+		{"log-level", "debug", "command line"},
+		{"workers", "6", "env QA_BOT_WORKERS"},
+		{"config", config, "env QA_BOT_CONFIG"},
+		{"db", "file.db", "config file"},
+		{"debug", "true", "config file"},
+		{"token", "xoxb-secret", "file " + secret},
+	}
+	for _, v := range cases {
+		if value := fs.Lookup(v.name).Value.String(); value != v.value || sources[v.name] != v.source {
+			t.Errorf("For %s, actual value: %s from %s, expected: %s from %s", v.name, value, sources[v.name], v.value, v.source)
+		}
+	}
+
+	var out bytes.Buffer
+	printSettings(fs, sources, &out)
+	if strings.Contains(out.String(), "xoxb-secret") || !strings.Contains(out.String(), `token="<redacted>"`) {
+		t.Errorf("Secret isn't redacted:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), `workers="6"`+"\t# env QA_BOT_WORKERS") {
+		t.Errorf("Setting isn't printed with its source:\n%s", out.String())
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	config := writeFile(t, "config.json", `{
+		"settings": {"db": "file.db"},
+		"dedup": true,
+		"workspaces": [{"name": "one", "token": "xoxb-one", "routes": [{"name": "jobs", "from": ["general"], "to": ["jobs"],
+			"external": [{"name": "hook", "webhook": "https://hooks.slack.com/services/T1/B1/x"}]}]}],
+		"digest": {"schedule": "0 10 * * 1", "channel": "general", "period": "7d"},
+		"webhooks": [{"name": "board", "url": "https://jobs.example.com/hook", "secret": "s3cret"}],
+		"api": {"token_file": "/run/secrets/api"}
+	}`)
+
+	var out bytes.Buffer
+	if err := printConfig(config, &out); err != nil {
+		t.Fatal("Can't print config: ", err)
+	}
+	for _, secret := range []string{"xoxb-one", "hooks.slack.com", "s3cret", "file.db"} {
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("Config contains %q:\n%s", secret, out.String())
+		}
+	}
+	for _, line := range []string{`"token": "<redacted>"`, `"secret": "<redacted>"`, `"webhook": "<redacted>"`,
+		`"token_file": "/run/secrets/api"`, `"period": "7d"`, `"url": "https://jobs.example.com/hook"`, `"dedup": true`} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Config doesn't contain %q:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestLoadSettingsErrors(t *testing.T) {
+	secret := writeFile(t, "token", "xoxb-secret")
+	cases := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-token", "xoxb-1", "-token-file", secret}, "Can't read token: Set either the secret or the file with it, not both"},
+		{[]string{"-token-file", "/nonexistent"}, "Can't read token"},
+		{[]string{"-config", writeFile(t, "c.json", `{"settings": {"tokn": "x"}}`)}, "Unknown setting in config file: tokn"},
+		{[]string{"-config", writeFile(t, "c.json", `{"settings": {"workers": "many"}}`)}, "Wrong value of workers from config file"},
+	}
+
+	for _, v := range cases {
+		fs := testFlags()
+		fs.Parse(v.args)
+		_, err := loadSettings(fs, func(string) (string, bool) { return "", false })
+		if err == nil || !strings.HasPrefix(err.Error(), v.err) {
+			t.Errorf("For args: %v, actual error: %v, expected: %s", v.args, err, v.err)
+		}
+	}
+}
+
+func TestSubcommandsResolveDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal("Can't open DB: ", err)
+	}
+	// The bot creates buckets and the search index the commands read.
+	if _, err := bot.NewBot(bot.Config{}, bot.Deps{Store: db}); err != nil {
+		t.Fatal("Can't create bot: ", err)
+	}
+	db.Close()
+	defer func(db, config string) {
+		*dbPath, *configPath = db, config
+	}(*dbPath, *configPath)
+
+	config := writeFile(t, "config.json", `{"settings": {"db": "`+path+`"}}`)
+	for name, value := range map[string]string{"QA_BOT_DB": path, "QA_BOT_CONFIG": config} {
+		t.Run(name, func(t *testing.T) {
+			*dbPath, *configPath = "repost.db", ""
+			t.Setenv(name, value)
+			if err := runStats([]string{"-period", "30d"}); err != nil {
+				t.Error("Stats failed: ", err)
+			}
+			if err := runSearch([]string{"manual"}); err != nil {
+				t.Error("Search failed: ", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/artemnikitin/qa-slack-bot/bot"
+)
+
+var workspaceName = regexp.MustCompile("^[a-z0-9][a-z0-9_-]*$")
+
+// workspace is one Slack workspace the process serves with its own
+// connection, DB file and routes. Without a "workspaces" list in the config
+// file the flags describe a single workspace with an empty name.
+type workspace struct {
+	Name       string `json:"name"`
+	Token      string `json:"token"`
+	TokenFile  string `json:"token_file"`
+	User       string `json:"user"`
+	DB         string `json:"db"`
+	SlashToken string `json:"verification_token"`
+	bot.Config
+}
+
+// loadWorkspaces reads the "workspaces" list and the "dedup" switch of the
+// config file. DB files default to the DB path of the flags with the name
+// of the workspace appended, the file itself is shared by the workspaces
+// for dedup.
+func loadWorkspaces(path, sharedDB string) ([]*workspace, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	var file struct {
+		Workspaces []*workspace `json:"workspaces"`
+		Dedup      bool         `json:"dedup"`
+	}
+	if err := json.NewDecoder(f).Decode(&file); err != nil {
+		return nil, false, errors.New("Can't parse config file: " + err.Error())
+	}
+
+	names := make(map[string]bool)
+	dbs := map[string]bool{filepath.Clean(sharedDB): true}
+	for _, ws := range file.Workspaces {
+		if !workspaceName.MatchString(ws.Name) {
+			return nil, false, errors.New("Wrong name of workspace " + ws.Name + ": use lowercase letters, digits, - and _")
+		}
+		if names[ws.Name] {
+			return nil, false, errors.New("Workspace " + ws.Name + " is defined twice")
+		}
+		names[ws.Name] = true
+
+		if ws.Token, err = bot.ReadSecret(ws.Token, ws.TokenFile); err != nil {
+			return nil, false, errors.New("Can't read token of workspace " + ws.Name + ": " + err.Error())
+		}
+		if ws.Token == "" {
+			return nil, false, errors.New("Workspace " + ws.Name + " has no token")
+		}
+		if len(ws.Routes) == 0 {
+			return nil, false, errors.New("Workspace " + ws.Name + " has no routes")
+		}
+		if ws.Categories == nil {
+			ws.Categories = bot.DefaultCategories()
+		}
+		if ws.DB == "" {
+			ws.DB = strings.TrimSuffix(sharedDB, ".db") + "-" + ws.Name + ".db"
+		}
+		// Bolt locks the file, a second open of it would block forever.
+		if dbs[filepath.Clean(ws.DB)] {
+			return nil, false, errors.New("Workspace " + ws.Name + " uses DB " + ws.DB + " of another workspace")
+		}
+		dbs[filepath.Clean(ws.DB)] = true
+	}
+	return file.Workspaces, file.Dedup, nil
+}
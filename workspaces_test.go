@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadWorkspaces(t *testing.T) {
+	secret := writeFile(t, "token", "xoxb-two\n")
+	routes := `"routes": [{"name": "jobs", "from": ["general"], "to": ["jobs"]}]`
+	cases := []struct {
+		config, err string
+	}{
+		{`{"workspaces": [{"name": "one", "token": "xoxb-one", ` + routes + `}, {"name": "two", "token_file": "` + secret + `", "user": "robot", "db": "two.db", ` + routes + `}], "dedup": true}`, ""},
+		{`{"workspaces": [{"name": "One", "token": "x", ` + routes + `}]}`, "Wrong name of workspace One"},
+		{`{"workspaces": [{"name": "one", "token": "x", ` + routes + `}, {"name": "one", "token": "x", ` + routes + `}]}`, "Workspace one is defined twice"},
+		{`{"workspaces": [{"name": "one", ` + routes + `}]}`, "Workspace one has no token"},
+		{`{"workspaces": [{"name": "one", "token": "x", "token_file": "` + secret + `", ` + routes + `}]}`, "Can't read token of workspace one: Set either the secret or the file with it"},
+		{`{"workspaces": [{"name": "one", "token": "x"}]}`, "Workspace one has no routes"},
+		{`{"workspaces": [{"name": "one", "token": "x", "db": "repost.db", ` + routes + `}]}`, "uses DB repost.db of another workspace"},
+	}
+
+	for _, v := range cases {
+		workspaces, dedup, err := loadWorkspaces(writeFile(t, "config.json", v.config), "repost.db")
+		if v.err != "" {
+			if err == nil || !strings.Contains(err.Error(), v.err) {
+				t.Errorf("For config %s, actual error: %v, expected: %s", v.config, err, v.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal("Can't load workspaces: ", err)
+		}
+		if !dedup || len(workspaces) != 2 {
+			t.Fatalf("Actual dedup: %v, workspaces: %+v", dedup, workspaces)
+		}
+		one, two := workspaces[0], workspaces[1]
+		if one.Token != "xoxb-one" || one.DB != "repost-one.db" || len(one.Routes) != 1 || one.Categories == nil {
+			t.Errorf("Wrong first workspace: %+v", one)
+		}
+		if two.Token != "xoxb-two" || two.User != "robot" || two.DB != "two.db" {
+			t.Errorf("Wrong second workspace: %+v", two)
+		}
+	}
+}